@@ -0,0 +1,50 @@
+package logasync
+
+import (
+	"go.uber.org/zap/zapcore"
+)
+
+// NewCore creates a zap core like zapcore.NewCore, that encodes the entries
+// on the calling goroutine and writes them through w, so that the overflow
+// policy of w knows their level. The entries above ErrorLevel are written
+// before Write returns, as the program may be about to exit.
+func NewCore(enc zapcore.Encoder, w *Writer, enab zapcore.LevelEnabler) zapcore.Core {
+	return &core{LevelEnabler: enab, enc: enc, w: w}
+}
+
+type core struct {
+	zapcore.LevelEnabler
+	enc zapcore.Encoder
+	w   *Writer
+}
+
+func (c *core) With(fields []zapcore.Field) zapcore.Core {
+	clone := &core{LevelEnabler: c.LevelEnabler, enc: c.enc.Clone(), w: c.w}
+	for i := range fields {
+		fields[i].AddTo(clone.enc)
+	}
+	return clone
+}
+
+func (c *core) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *core) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	buf, err := c.enc.EncodeEntry(ent, fields)
+	if err != nil {
+		return err
+	}
+	c.w.enqueue(ent.Level, buf)
+	if ent.Level > zapcore.ErrorLevel {
+		return c.w.Sync()
+	}
+	return nil
+}
+
+func (c *core) Sync() error {
+	return c.w.Sync()
+}
@@ -0,0 +1,330 @@
+package logasync
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// DefaultSize is the number of entries buffered by default.
+	DefaultSize = 4096
+	// DefaultFlushInterval is how often the destination is synced by default.
+	DefaultFlushInterval = time.Second
+)
+
+// Policy is what happens to an entry written while the buffer is full.
+type Policy int
+
+const (
+	// Block waits for the buffer to have room.
+	Block Policy = iota
+	// DropNewest drops the entry being written.
+	DropNewest
+	// DropOldest drops the oldest buffered entry to make room.
+	DropOldest
+	// DropBelowLevel drops the entry being written if it is below
+	// DropLevel, else the oldest buffered entry below DropLevel. It waits
+	// for room if there is no such entry.
+	DropBelowLevel
+)
+
+var policyNames = []string{"block", "drop_newest", "drop_oldest", "drop_below_level"}
+
+func (p Policy) String() string {
+	if p >= 0 && int(p) < len(policyNames) {
+		return policyNames[p]
+	}
+	return fmt.Sprintf("Policy(%d)", int(p))
+}
+
+// UnmarshalText unmarshals the name of a policy, such as "drop_oldest".
+func (p *Policy) UnmarshalText(text []byte) error {
+	name := strings.ToLower(string(text))
+	for i, n := range policyNames {
+		if n == name {
+			*p = Policy(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unrecognized policy: %q", text)
+}
+
+// Configuration is the set of parameters of an asynchronous writer.
+type Configuration struct {
+	// Size is the maximum number of buffered entries, DefaultSize if zero.
+	Size int
+	// Policy applies when the buffer is full.
+	Policy Policy
+	// DropLevel is the level below which DropBelowLevel drops entries.
+	DropLevel zapcore.Level
+	// FlushInterval is how often the destination is synced while entries
+	// are written, DefaultFlushInterval if zero.
+	FlushInterval time.Duration
+}
+
+// Stats are the counters of a Writer.
+type Stats struct {
+	// Buffered is the number of entries waiting to be written.
+	Buffered int
+	// Written is the number of entries written to the destination.
+	Written uint64
+	// Dropped is the number of entries dropped because the buffer was full
+	// or the writer closed.
+	Dropped uint64
+}
+
+// noLevel is the level of the entries written by Write, which are never
+// dropped for their level.
+const noLevel = zapcore.FatalLevel + 1
+
+type item struct {
+	lvl zapcore.Level
+	buf *buffer.Buffer
+}
+
+// Writer is a zapcore.WriteSyncer that buffers the entries in a bounded ring
+// and writes them to its destination from a goroutine, so that a slow
+// destination doesn't block the callers. Use NewCore to write entries with
+// their level.
+type Writer struct {
+	ws  zapcore.WriteSyncer
+	cfg Configuration
+
+	mu      sync.Mutex
+	space   *sync.Cond // signaled when items are taken
+	drained *sync.Cond // signaled when the writer is idle and the ring empty
+	ring    []item
+	head    int
+	count   int
+	writing bool
+	closed  bool
+
+	written uint64 // atomic
+	dropped uint64 // atomic
+
+	wake chan struct{}
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewWriter starts writing to ws in the background. Close stops it.
+func NewWriter(ws zapcore.WriteSyncer, cfg Configuration) (*Writer, error) {
+	if cfg.Size < 0 || cfg.FlushInterval < 0 {
+		return nil, errors.New("logasync: negative limits are not allowed")
+	}
+	if cfg.Policy < Block || cfg.Policy > DropBelowLevel {
+		return nil, fmt.Errorf("logasync: unknown policy %v", cfg.Policy)
+	}
+	if cfg.Size == 0 {
+		cfg.Size = DefaultSize
+	}
+	if cfg.FlushInterval == 0 {
+		cfg.FlushInterval = DefaultFlushInterval
+	}
+	w := &Writer{
+		ws:   ws,
+		cfg:  cfg,
+		ring: make([]item, cfg.Size),
+		wake: make(chan struct{}, 1),
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	w.space = sync.NewCond(&w.mu)
+	w.drained = sync.NewCond(&w.mu)
+	go w.run()
+	return w, nil
+}
+
+var _pool = buffer.NewPool()
+
+// Write implements io.Writer. p is copied and written later.
+func (w *Writer) Write(p []byte) (int, error) {
+	buf := _pool.Get()
+	_, _ = buf.Write(p)
+	w.enqueue(noLevel, buf)
+	return len(p), nil
+}
+
+// WriteLevel is Write for an entry at lvl, which the policy may drop for its
+// level. It is used by the cores that format their own entries, such as the
+// syslog core.
+func (w *Writer) WriteLevel(lvl zapcore.Level, p []byte) (int, error) {
+	buf := _pool.Get()
+	_, _ = buf.Write(p)
+	w.enqueue(lvl, buf)
+	return len(p), nil
+}
+
+// enqueue buffers buf, which is freed once written or dropped.
+func (w *Writer) enqueue(lvl zapcore.Level, buf *buffer.Buffer) {
+	w.mu.Lock()
+	for !w.closed && w.count == len(w.ring) {
+		if w.makeRoom(lvl) {
+			break
+		}
+		if w.cfg.Policy == DropNewest || (w.cfg.Policy == DropBelowLevel && lvl < w.cfg.DropLevel) {
+			w.mu.Unlock()
+			w.drop(buf)
+			return
+		}
+		w.space.Wait()
+	}
+	if w.closed {
+		w.mu.Unlock()
+		w.drop(buf)
+		return
+	}
+	w.ring[(w.head+w.count)%len(w.ring)] = item{lvl: lvl, buf: buf}
+	w.count++
+	w.mu.Unlock()
+
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// makeRoom drops a buffered item as the policy allows, for an entry at lvl.
+// It must be called with mu held.
+func (w *Writer) makeRoom(lvl zapcore.Level) bool {
+	switch w.cfg.Policy {
+	case DropOldest:
+		old := w.ring[w.head]
+		w.ring[w.head] = item{}
+		w.head = (w.head + 1) % len(w.ring)
+		w.count--
+		w.drop(old.buf)
+		return true
+	case DropBelowLevel:
+		if lvl < w.cfg.DropLevel {
+			return false
+		}
+		for i := 0; i < w.count; i++ {
+			idx := (w.head + i) % len(w.ring)
+			if w.ring[idx].lvl >= w.cfg.DropLevel {
+				continue
+			}
+			old := w.ring[idx]
+			// Shift the newer items down over the dropped one.
+			for j := i; j < w.count-1; j++ {
+				w.ring[(w.head+j)%len(w.ring)] = w.ring[(w.head+j+1)%len(w.ring)]
+			}
+			w.ring[(w.head+w.count-1)%len(w.ring)] = item{}
+			w.count--
+			w.drop(old.buf)
+			return true
+		}
+	}
+	return false
+}
+
+func (w *Writer) drop(buf *buffer.Buffer) {
+	buf.Free()
+	atomic.AddUint64(&w.dropped, 1)
+}
+
+func (w *Writer) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.cfg.FlushInterval)
+	defer ticker.Stop()
+	dirty := false
+	for {
+		select {
+		case <-w.wake:
+			dirty = w.flush() || dirty
+		case <-ticker.C:
+			dirty = w.flush() || dirty
+			if dirty {
+				_ = w.ws.Sync()
+				dirty = false
+			}
+		case <-w.stop:
+			w.flush()
+			return
+		}
+	}
+}
+
+// flush writes the buffered items until there are none left, and reports
+// whether it wrote any.
+func (w *Writer) flush() bool {
+	var batch []item
+	wrote := false
+	for {
+		w.mu.Lock()
+		if w.count == 0 {
+			w.writing = false
+			w.drained.Broadcast()
+			w.mu.Unlock()
+			return wrote
+		}
+		w.writing = true
+		batch = batch[:0]
+		for ; w.count > 0; w.count-- {
+			batch = append(batch, w.ring[w.head])
+			w.ring[w.head] = item{}
+			w.head = (w.head + 1) % len(w.ring)
+		}
+		w.space.Broadcast()
+		w.mu.Unlock()
+
+		for _, it := range batch {
+			if _, err := w.ws.Write(it.buf.Bytes()); err != nil {
+				_, _ = fmt.Fprintf(os.Stderr, "logasync: write failed [%v]\n", err)
+			}
+			it.buf.Free()
+		}
+		atomic.AddUint64(&w.written, uint64(len(batch)))
+		wrote = true
+	}
+}
+
+// Sync waits for the buffered entries to be written, then syncs the
+// destination.
+func (w *Writer) Sync() error {
+	w.mu.Lock()
+	for !w.closed && (w.count > 0 || w.writing) {
+		w.drained.Wait()
+	}
+	w.mu.Unlock()
+	return w.ws.Sync()
+}
+
+// Close writes the buffered entries and stops the writer. The entries
+// written afterwards are dropped. It doesn't close the destination.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	// Wake up the blocked writers and Sync callers, they don't wait anymore.
+	w.space.Broadcast()
+	w.drained.Broadcast()
+	w.mu.Unlock()
+
+	close(w.stop)
+	<-w.done
+	return w.ws.Sync()
+}
+
+// Stats returns the counters of the writer.
+func (w *Writer) Stats() Stats {
+	w.mu.Lock()
+	buffered := w.count
+	w.mu.Unlock()
+	return Stats{
+		Buffered: buffered,
+		Written:  atomic.LoadUint64(&w.written),
+		Dropped:  atomic.LoadUint64(&w.dropped),
+	}
+}
@@ -0,0 +1,311 @@
+package logasync
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// gate is a destination whose writes block until it is opened.
+type gate struct {
+	mu      sync.Mutex
+	writes  []string
+	syncs   int
+	open    chan struct{}
+	started chan struct{} // closed by the first write
+	once    sync.Once
+}
+
+func newGate() *gate {
+	return &gate{open: make(chan struct{}), started: make(chan struct{})}
+}
+
+func (g *gate) Write(p []byte) (int, error) {
+	g.once.Do(func() { close(g.started) })
+	<-g.open
+	g.mu.Lock()
+	g.writes = append(g.writes, string(p))
+	g.mu.Unlock()
+	return len(p), nil
+}
+
+func (g *gate) Sync() error {
+	g.mu.Lock()
+	g.syncs++
+	g.mu.Unlock()
+	return nil
+}
+
+func (g *gate) written() []string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]string(nil), g.writes...)
+}
+
+func (g *gate) synced() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.syncs
+}
+
+func newTestWriter(t *testing.T, ws zapcore.WriteSyncer, cfg Configuration) *Writer {
+	t.Helper()
+	w, err := NewWriter(ws, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = w.Close() })
+	return w
+}
+
+// stall writes a first entry and waits for the destination to block on it,
+// so that the next entries stay in the ring.
+func stall(t *testing.T, w *Writer, g *gate) {
+	t.Helper()
+	write(t, w, zapcore.InfoLevel, "0")
+	select {
+	case <-g.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("destination was not written")
+	}
+}
+
+func write(t *testing.T, w *Writer, lvl zapcore.Level, s string) {
+	t.Helper()
+	if _, err := w.WriteLevel(lvl, []byte(s)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// returns runs f on a goroutine and returns a channel closed when it returns.
+func returns(f func()) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		f()
+	}()
+	return done
+}
+
+func checkPending(t *testing.T, what string, done <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-done:
+		t.Fatalf("%s did not wait", what)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func checkReturned(t *testing.T, what string, done <-chan struct{}) {
+	t.Helper()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("%s did not return", what)
+	}
+}
+
+func checkWritten(t *testing.T, g *gate, want ...string) {
+	t.Helper()
+	if got := g.written(); strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("written %q, want %q", got, want)
+	}
+}
+
+func TestWriterOrder(t *testing.T) {
+	g := newGate()
+	close(g.open)
+	w := newTestWriter(t, g, Configuration{Size: 4})
+
+	var want []string
+	for i := 0; i < 100; i++ {
+		s := fmt.Sprint(i)
+		want = append(want, s)
+		write(t, w, zapcore.InfoLevel, s)
+	}
+	if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	checkWritten(t, g, want...)
+	if stats := w.Stats(); stats != (Stats{Written: 100}) {
+		t.Errorf("stats are %+v", stats)
+	}
+}
+
+func TestWriterPolicies(t *testing.T) {
+	tests := []struct {
+		policy  Policy
+		writes  []zapcore.Level // of the entries "1", "2"... after the stalled "0"
+		written []string
+		dropped uint64
+	}{
+		{
+			policy:  DropNewest,
+			writes:  []zapcore.Level{zapcore.InfoLevel, zapcore.InfoLevel, zapcore.ErrorLevel},
+			written: []string{"0", "1", "2"},
+			dropped: 1,
+		},
+		{
+			policy:  DropOldest,
+			writes:  []zapcore.Level{zapcore.ErrorLevel, zapcore.InfoLevel, zapcore.InfoLevel, zapcore.InfoLevel},
+			written: []string{"0", "3", "4"},
+			dropped: 2,
+		},
+		{
+			policy: DropBelowLevel,
+			// "3" takes the place of "1", "4" is dropped as the ring holds
+			// warnings and errors only.
+			writes:  []zapcore.Level{zapcore.InfoLevel, zapcore.WarnLevel, zapcore.ErrorLevel, zapcore.DebugLevel},
+			written: []string{"0", "2", "3"},
+			dropped: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy.String(), func(t *testing.T) {
+			g := newGate()
+			w := newTestWriter(t, g, Configuration{Size: 2, Policy: tt.policy, DropLevel: zapcore.WarnLevel})
+			stall(t, w, g)
+			for i, lvl := range tt.writes {
+				write(t, w, lvl, fmt.Sprint(i+1))
+			}
+			if stats := w.Stats(); stats.Buffered != 2 || stats.Dropped != tt.dropped {
+				t.Errorf("stats are %+v while stalled", stats)
+			}
+			close(g.open)
+			if err := w.Sync(); err != nil {
+				t.Fatal(err)
+			}
+			checkWritten(t, g, tt.written...)
+			want := Stats{Written: uint64(len(tt.written)), Dropped: tt.dropped}
+			if stats := w.Stats(); stats != want {
+				t.Errorf("stats are %+v, want %+v", stats, want)
+			}
+		})
+	}
+}
+
+func TestWriterBlocks(t *testing.T) {
+	g := newGate()
+	w := newTestWriter(t, g, Configuration{Size: 2})
+	stall(t, w, g)
+	write(t, w, zapcore.InfoLevel, "1")
+	write(t, w, zapcore.InfoLevel, "2")
+
+	done := returns(func() { write(t, w, zapcore.InfoLevel, "3") })
+	checkPending(t, "write to a full buffer", done)
+	close(g.open)
+	checkReturned(t, "write", done)
+
+	if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	checkWritten(t, g, "0", "1", "2", "3")
+	if stats := w.Stats(); stats.Dropped != 0 {
+		t.Errorf("dropped %d entries", stats.Dropped)
+	}
+}
+
+func TestDropBelowLevelBlocksWithoutLowerEntries(t *testing.T) {
+	g := newGate()
+	w := newTestWriter(t, g, Configuration{Size: 1, Policy: DropBelowLevel, DropLevel: zapcore.WarnLevel})
+	stall(t, w, g)
+	write(t, w, zapcore.ErrorLevel, "1")
+
+	done := returns(func() { write(t, w, zapcore.WarnLevel, "2") })
+	checkPending(t, "warning written to a buffer of errors", done)
+	close(g.open)
+	checkReturned(t, "write", done)
+
+	if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	checkWritten(t, g, "0", "1", "2")
+}
+
+func TestSyncWaitsForDrain(t *testing.T) {
+	g := newGate()
+	w := newTestWriter(t, g, Configuration{Size: 4})
+	stall(t, w, g)
+	write(t, w, zapcore.InfoLevel, "1")
+
+	done := returns(func() {
+		if err := w.Sync(); err != nil {
+			t.Error(err)
+		}
+	})
+	checkPending(t, "Sync", done)
+	close(g.open)
+	checkReturned(t, "Sync", done)
+	checkWritten(t, g, "0", "1")
+	if g.synced() == 0 {
+		t.Error("destination was not synced")
+	}
+}
+
+func TestClose(t *testing.T) {
+	g := newGate()
+	w := newTestWriter(t, g, Configuration{Size: 4})
+	stall(t, w, g)
+	write(t, w, zapcore.InfoLevel, "1")
+
+	done := returns(func() {
+		if err := w.Close(); err != nil {
+			t.Error(err)
+		}
+	})
+	close(g.open)
+	checkReturned(t, "Close", done)
+	checkWritten(t, g, "0", "1")
+
+	// Writes and syncs after Close don't block.
+	if _, err := w.Write([]byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	checkWritten(t, g, "0", "1")
+	if stats := w.Stats(); stats != (Stats{Written: 2, Dropped: 1}) {
+		t.Errorf("stats are %+v", stats)
+	}
+}
+
+func TestCloseReleasesBlockedWriters(t *testing.T) {
+	g := newGate()
+	w := newTestWriter(t, g, Configuration{Size: 1})
+	stall(t, w, g)
+	write(t, w, zapcore.InfoLevel, "1")
+
+	blocked := returns(func() { write(t, w, zapcore.InfoLevel, "2") })
+	checkPending(t, "write to a full buffer", blocked)
+	closed := returns(func() { _ = w.Close() })
+	checkReturned(t, "blocked write", blocked)
+	close(g.open)
+	checkReturned(t, "Close", closed)
+
+	checkWritten(t, g, "0", "1")
+	if stats := w.Stats(); stats.Dropped != 1 {
+		t.Errorf("dropped %d entries, want 1", stats.Dropped)
+	}
+}
+
+func TestCoreSyncsAboveError(t *testing.T) {
+	g := newGate()
+	close(g.open)
+	w := newTestWriter(t, g, Configuration{Size: 4, FlushInterval: time.Hour})
+	enc := zapcore.NewConsoleEncoder(zapcore.EncoderConfig{MessageKey: "msg"})
+	core := NewCore(enc, w, zapcore.DebugLevel)
+
+	if err := core.Write(zapcore.Entry{Level: zapcore.DPanicLevel, Message: "boom"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	// Written before Write returned, without waiting for Sync.
+	checkWritten(t, g, "boom\n")
+	if g.synced() != 1 {
+		t.Errorf("destination synced %d times, want 1", g.synced())
+	}
+}
@@ -0,0 +1,962 @@
+package log
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	logasync "github.com/liasece/log/async"
+	"github.com/liasece/log/encoder"
+	logjournald "github.com/liasece/log/journald"
+	logloki "github.com/liasece/log/loki"
+	logrotate "github.com/liasece/log/rotate"
+	logsentry "github.com/liasece/log/sentry"
+	logship "github.com/liasece/log/ship"
+	logsyslog "github.com/liasece/log/syslog"
+	"github.com/mitchellh/mapstructure"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Config is the logging configuration read from the "logging" key of a viper
+// configuration. A complete example in YAML:
+//
+//	logging:
+//	  level: info              # debug, info, warn, error, dpanic, panic, fatal
+//	  levels:                  # levels of the loggers created by Named
+//	    payments: debug
+//	    "payments.stripe": warn
+//	  encoding: console        # default encoding of the outputs: console, json, logfmt
+//	  color: auto              # colour the level of console outputs: auto, always, never
+//	  console_format: json     # context of console outputs: json, keyvalue, pretty
+//	  caller: true             # add the caller to every entry
+//	  trace_fields: default    # keys of the trace fields added by L: default, otel, ecs
+//	  stacktrace_level: error  # add a stack trace at and above this level
+//	  sampling:
+//	    tick: 1s               # sample per tick
+//	    initial: 100           # log the first N entries with the same message
+//	    thereafter: 100        # then every Mth entry
+//	  async:                   # write the stream, file, syslog and journald outputs from a goroutine
+//	    size: 4096             # entries buffered per output
+//	    policy: block          # when full: block, drop_newest, drop_oldest, drop_below_level
+//	    drop_level: warn       # level kept by drop_below_level
+//	    flush_interval: 1s     # how often the outputs are synced
+//	  fields:                  # fields added to every entry
+//	    service: payments
+//	  outputs:
+//	    - type: stdout         # stdout, stderr, file, syslog, journald, ship or loki
+//	    - type: file
+//	      path: /var/log/payments.log
+//	      encoding: json
+//	      level: warn          # drop the entries below this level
+//	      max_size: 100        # see logging.file
+//	      max_backups: 10
+//	    - type: syslog
+//	      level: warn
+//	      syslog:              # the local daemon if network and address are empty
+//	        network: tcp       # udp, tcp, unix, unixgram
+//	        address: logs.example.com:514
+//	        format: rfc5424    # rfc5424 or rfc3164
+//	        framing: auto      # auto, octet_counting, newline
+//	        facility: local0
+//	        app_name: payments
+//	        timeout: 5s
+//	        min_backoff: 500ms # before connecting again after a failure
+//	        max_backoff: 1m
+//	    - type: journald
+//	      path: /run/systemd/journal/socket # the default
+//	      journald:
+//	        identifier: payments
+//	    - type: ship           # send to a collector, spilling to disk while it is down
+//	      encoding: json
+//	      ship:
+//	        url: https://logs.example.com/ingest # or tcp://host:port
+//	        headers:
+//	          Authorization: Bearer secret
+//	        batch_size: 500
+//	        batch_interval: 1s
+//	        min_backoff: 500ms
+//	        max_backoff: 1m
+//	        timeout: 10s
+//	        queue_size: 10000  # entries kept in memory
+//	        dir: /var/spool/payments/logs # entries kept in memory only if empty
+//	        max_disk_size: 1024 # megabytes
+//	        segment_size: 16   # megabytes
+//	    - type: loki
+//	      encoding: logfmt     # of the lines, json by default
+//	      loki:
+//	        url: http://loki:3100/loki/api/v1/push
+//	        labels: [service, level] # fields grouping the entries into streams
+//	        format: protobuf   # protobuf (snappy) or json (gzip)
+//	        tenant_id: payments
+//	        headers:
+//	          Authorization: Basic c2VjcmV0
+//	        batch_size: 1000
+//	        batch_wait: 1s
+//	        timeout: 10s
+//	        min_backoff: 500ms
+//	        max_backoff: 1m
+//	        max_retries: 10
+//	        queue_size: 10000
+//	  file:                    # rotation of the file passed to InitLog
+//	    max_size: 100          # megabytes before rotation
+//	    rotation_time: 24h     # age of the file before rotation
+//	    max_age: 168h          # how long rotated files are kept
+//	    max_backups: 10        # how many rotated files are kept
+//	    compress: true         # gzip rotated files
+//	    local_time: false      # use local time in rotated file names
+//	  sentry:
+//	    dsn: https://key@sentry.example.com/1
+//	    environment: production
+//	    release: payments@1.2.3
+//	    level: error           # send entries at and above this level
+//	    flush_timeout: 5s
+//	    disable_stacktrace: false
+//	    breadcrumb_level: info # record the entries below level as breadcrumbs
+//	    max_breadcrumbs: 30    # breadcrumbs kept per scope
+//	    in_app_exclude:        # module prefixes of frames that are not in app
+//	      - github.com/labstack/echo
+//	    tags:
+//	      component: system
+//	    tag_keys: [tenant]     # fields sent as tags
+//	    user_keys:             # fields of the user: id, email, username, ip_address
+//	      id: user.id
+//	      email: user.email
+//	    context_keys:          # fields grouped into structured contexts
+//	      http: [http.method, http.path]
+//	    fingerprint_keys: [endpoint] # fields refining the grouping of events
+//	    rate_limit: 10         # events per second, unlimited if 0
+//	    rate_burst: 20
+//	    dedup_window: 1m       # drop the events repeating message and caller
+//
+// InitLog and ReloadLog set the keys of the trace fields to the preset of
+// trace_fields, the default one if it is empty, in place of the keys set by
+// SetTraceFieldNames.
+//
+// When no outputs are configured, entries go to stdout and, if InitLog was
+// given a file name, to that file. See ReloadLog and WatchConfig to apply
+// changes at runtime.
+type Config struct {
+	Level           string            `mapstructure:"level"`
+	Levels          map[string]string `mapstructure:"levels"`
+	Encoding        string            `mapstructure:"encoding"`
+	Color           string            `mapstructure:"color"`
+	ConsoleFormat   string            `mapstructure:"console_format"`
+	Caller          *bool             `mapstructure:"caller"`
+	StacktraceLevel string            `mapstructure:"stacktrace_level"`
+	TraceFields     string            `mapstructure:"trace_fields"`
+	Sampling        *SamplingConfig   `mapstructure:"sampling"`
+	Async           *AsyncConfig      `mapstructure:"async"`
+	Fields          map[string]string `mapstructure:"fields"`
+	Outputs         []OutputConfig    `mapstructure:"outputs"`
+	File            FileConfig        `mapstructure:"file"`
+	Sentry          *SentryConfig     `mapstructure:"sentry"`
+}
+
+// SamplingConfig caps the throughput of entries with the same level and
+// message, see zapcore.NewSamplerWithOptions.
+type SamplingConfig struct {
+	Tick       time.Duration `mapstructure:"tick"`
+	Initial    int           `mapstructure:"initial"`
+	Thereafter int           `mapstructure:"thereafter"`
+}
+
+// AsyncConfig writes the entries of the stdout, stderr, file, syslog and
+// journald outputs from a goroutine, through a bounded buffer, see
+// logasync.Writer.
+type AsyncConfig struct {
+	Size          int           `mapstructure:"size"`
+	Policy        string        `mapstructure:"policy"`
+	DropLevel     string        `mapstructure:"drop_level"`
+	FlushInterval time.Duration `mapstructure:"flush_interval"`
+}
+
+// FileConfig is the rotation policy of a file output.
+type FileConfig struct {
+	MaxSize      int           `mapstructure:"max_size"`
+	RotationTime time.Duration `mapstructure:"rotation_time"`
+	MaxAge       time.Duration `mapstructure:"max_age"`
+	MaxBackups   int           `mapstructure:"max_backups"`
+	Compress     bool          `mapstructure:"compress"`
+	LocalTime    bool          `mapstructure:"local_time"`
+}
+
+// OutputConfig is one destination of the log entries.
+type OutputConfig struct {
+	Type       string `mapstructure:"type"`
+	Path       string `mapstructure:"path"`
+	Encoding   string `mapstructure:"encoding"`
+	Level      string `mapstructure:"level"`
+	FileConfig `mapstructure:",squash"`
+	Syslog     SyslogConfig   `mapstructure:"syslog"`
+	Journald   JournaldConfig `mapstructure:"journald"`
+	Ship       ShipConfig     `mapstructure:"ship"`
+	Loki       LokiConfig     `mapstructure:"loki"`
+}
+
+// LokiConfig is the push endpoint of a loki output and how entries are
+// batched, see logloki.Configuration.
+type LokiConfig struct {
+	URL        string            `mapstructure:"url"`
+	Labels     []string          `mapstructure:"labels"`
+	Format     string            `mapstructure:"format"`
+	TenantID   string            `mapstructure:"tenant_id"`
+	Headers    map[string]string `mapstructure:"headers"`
+	BatchSize  int               `mapstructure:"batch_size"`
+	BatchWait  time.Duration     `mapstructure:"batch_wait"`
+	Timeout    time.Duration     `mapstructure:"timeout"`
+	MinBackoff time.Duration     `mapstructure:"min_backoff"`
+	MaxBackoff time.Duration     `mapstructure:"max_backoff"`
+	MaxRetries int               `mapstructure:"max_retries"`
+	QueueSize  int               `mapstructure:"queue_size"`
+}
+
+// ShipConfig is the collector of a ship output and its queue, see
+// logship.Configuration.
+type ShipConfig struct {
+	URL           string            `mapstructure:"url"`
+	ContentType   string            `mapstructure:"content_type"`
+	Headers       map[string]string `mapstructure:"headers"`
+	BatchSize     int               `mapstructure:"batch_size"`
+	BatchInterval time.Duration     `mapstructure:"batch_interval"`
+	MinBackoff    time.Duration     `mapstructure:"min_backoff"`
+	MaxBackoff    time.Duration     `mapstructure:"max_backoff"`
+	Timeout       time.Duration     `mapstructure:"timeout"`
+	QueueSize     int               `mapstructure:"queue_size"`
+	Dir           string            `mapstructure:"dir"`
+	MaxDiskSize   int               `mapstructure:"max_disk_size"`
+	SegmentSize   int               `mapstructure:"segment_size"`
+}
+
+// JournaldConfig is the SYSLOG_IDENTIFIER of the entries of a journald
+// output, whose socket is the path of the output.
+type JournaldConfig struct {
+	Identifier string `mapstructure:"identifier"`
+}
+
+// SyslogConfig is the connection of a syslog output, see
+// logsyslog.Configuration.
+type SyslogConfig struct {
+	Network    string        `mapstructure:"network"`
+	Address    string        `mapstructure:"address"`
+	Format     string        `mapstructure:"format"`
+	Framing    string        `mapstructure:"framing"`
+	Facility   string        `mapstructure:"facility"`
+	AppName    string        `mapstructure:"app_name"`
+	Hostname   string        `mapstructure:"hostname"`
+	Timeout    time.Duration `mapstructure:"timeout"`
+	MinBackoff time.Duration `mapstructure:"min_backoff"`
+	MaxBackoff time.Duration `mapstructure:"max_backoff"`
+}
+
+// SentryConfig sends the entries at and above Level to sentry.
+type SentryConfig struct {
+	DSN               string              `mapstructure:"dsn"`
+	Environment       string              `mapstructure:"environment"`
+	Release           string              `mapstructure:"release"`
+	Debug             bool                `mapstructure:"debug"`
+	Level             string              `mapstructure:"level"`
+	FlushTimeout      time.Duration       `mapstructure:"flush_timeout"`
+	DisableStacktrace bool                `mapstructure:"disable_stacktrace"`
+	Tags              map[string]string   `mapstructure:"tags"`
+	BreadcrumbLevel   string              `mapstructure:"breadcrumb_level"`
+	MaxBreadcrumbs    int                 `mapstructure:"max_breadcrumbs"`
+	InAppExclude      []string            `mapstructure:"in_app_exclude"`
+	TagKeys           []string            `mapstructure:"tag_keys"`
+	UserKeys          map[string]string   `mapstructure:"user_keys"`
+	ContextKeys       map[string][]string `mapstructure:"context_keys"`
+	FingerprintKeys   []string            `mapstructure:"fingerprint_keys"`
+	RateLimit         float64             `mapstructure:"rate_limit"`
+	RateBurst         int                 `mapstructure:"rate_burst"`
+	DedupWindow       time.Duration       `mapstructure:"dedup_window"`
+}
+
+const (
+	outputStdout   = "stdout"
+	outputStderr   = "stderr"
+	outputFile     = "file"
+	outputSyslog   = "syslog"
+	outputJournald = "journald"
+	outputShip     = "ship"
+	outputLoki     = "loki"
+
+	encodingConsole = "console"
+	encodingJSON    = "json"
+	encodingLogfmt  = "logfmt"
+
+	colorAuto   = "auto"
+	colorAlways = "always"
+	colorNever  = "never"
+
+	consoleFormatJSON     = "json"
+	consoleFormatKeyValue = "keyvalue"
+	consoleFormatPretty   = "pretty"
+)
+
+// LoadConfig reads the "logging" key of cfg. Unknown keys are reported as
+// errors so that typos don't go unnoticed.
+func LoadConfig(cfg *viper.Viper) (*Config, error) {
+	c := &Config{}
+	if cfg == nil {
+		return c, nil
+	}
+	err := cfg.UnmarshalKey("logging", c, func(dc *mapstructure.DecoderConfig) {
+		dc.ErrorUnused = true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid logging configuration: %w", err)
+	}
+	return c, nil
+}
+
+func parseLevel(key, text string, def zapcore.Level) (zapcore.Level, error) {
+	if text == "" {
+		return def, nil
+	}
+	var lvl zapcore.Level
+	if err := lvl.UnmarshalText([]byte(strings.ToLower(text))); err != nil {
+		return def, fmt.Errorf("%s: %w", key, err)
+	}
+	return lvl, nil
+}
+
+// outputs returns the configured outputs, or the default ones if there are
+// none: stdout and the file passed to InitLog.
+func (c *Config) outputs(fileName string) []OutputConfig {
+	if len(c.Outputs) > 0 {
+		return c.Outputs
+	}
+	outputs := []OutputConfig{{Type: outputStdout}}
+	if fileName != "" {
+		outputs = append(outputs, OutputConfig{Type: outputFile, Path: fileName, FileConfig: c.File})
+	}
+	return outputs
+}
+
+// applyTraceFields sets the trace field names of the configured preset, the
+// default one if none is.
+func (c *Config) applyTraceFields() {
+	if names, err := parseTraceFields("trace_fields", c.TraceFields); err == nil {
+		SetTraceFieldNames(names)
+	}
+}
+
+// validate checks everything that can be checked without opening an output.
+func (c *Config) validate(fileName string) error {
+	var errs []string
+	add := func(err error) {
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	_, err := parseLevel("level", c.Level, zapcore.DebugLevel)
+	add(err)
+	_, err = parseLevel("stacktrace_level", c.StacktraceLevel, zapcore.DebugLevel)
+	add(err)
+	for name, text := range c.Levels {
+		if text == "" {
+			add(fmt.Errorf("levels.%s: empty level", name))
+			continue
+		}
+		_, err = parseLevel("levels."+name, text, zapcore.DebugLevel)
+		add(err)
+	}
+	add(validateEncoding("encoding", c.Encoding))
+	switch c.Color {
+	case "", colorAuto, colorAlways, colorNever:
+	default:
+		add(fmt.Errorf("color: unknown value %q", c.Color))
+	}
+	switch c.ConsoleFormat {
+	case "", consoleFormatJSON, consoleFormatKeyValue, consoleFormatPretty:
+	default:
+		add(fmt.Errorf("console_format: unknown value %q", c.ConsoleFormat))
+	}
+	_, err = parseTraceFields("trace_fields", c.TraceFields)
+	add(err)
+	if s := c.Sampling; s != nil && (s.Initial < 0 || s.Thereafter < 0 || s.Tick < 0) {
+		add(errors.New("sampling: negative values are not allowed"))
+	} else if s != nil && s.Initial > 0 && s.Thereafter == 0 {
+		add(errors.New("sampling: thereafter must be at least 1"))
+	}
+	if a := c.Async; a != nil {
+		if a.Size < 0 || a.FlushInterval < 0 {
+			add(errors.New("async: negative values are not allowed"))
+		}
+		_, err = a.configuration()
+		add(err)
+	}
+	shipDirs := make(map[string]string) // of the outputs
+	for i, o := range c.outputs(fileName) {
+		key := fmt.Sprintf("outputs[%d]", i)
+		switch o.Type {
+		case outputStdout, outputStderr:
+		case outputFile:
+			if o.Path == "" {
+				add(fmt.Errorf("%s: file output without path", key))
+			}
+			if o.MaxSize < 0 || o.MaxBackups < 0 || o.MaxAge < 0 || o.RotationTime < 0 {
+				add(fmt.Errorf("%s: negative rotation limits are not allowed", key))
+			}
+		case outputSyslog:
+			_, err = o.Syslog.configuration(key + ".syslog")
+			add(err)
+		case outputJournald:
+		case outputShip:
+			add(o.Ship.validate(key + ".ship"))
+			if dir := filepath.Clean(o.Ship.Dir); o.Ship.Dir != "" {
+				if other, ok := shipDirs[dir]; ok {
+					add(fmt.Errorf("%s.ship.dir: already used by %s", key, other))
+				}
+				shipDirs[dir] = key
+			}
+		case outputLoki:
+			_, err = o.Loki.configuration(key + ".loki")
+			add(err)
+		default:
+			add(fmt.Errorf("%s: unknown output type %q", key, o.Type))
+		}
+		add(validateEncoding(key+".encoding", o.Encoding))
+		_, err = parseLevel(key+".level", o.Level, zapcore.DebugLevel)
+		add(err)
+	}
+	if s := c.Sentry; s != nil {
+		_, err = parseLevel("sentry.level", s.Level, zapcore.ErrorLevel)
+		add(err)
+		if s.DSN == "" {
+			add(errors.New("sentry: dsn is required"))
+		}
+		_, err = parseLevel("sentry.breadcrumb_level", s.BreadcrumbLevel, zapcore.DebugLevel)
+		add(err)
+		if s.MaxBreadcrumbs < 0 {
+			add(errors.New("sentry: max_breadcrumbs must not be negative"))
+		}
+		if s.RateLimit < 0 || s.RateBurst < 0 || s.DedupWindow < 0 {
+			add(errors.New("sentry: negative rate limits are not allowed"))
+		}
+		for attr := range s.UserKeys {
+			switch attr {
+			case logsentry.UserID, logsentry.UserEmail, logsentry.UserUsername, logsentry.UserIPAddress:
+			default:
+				add(fmt.Errorf("sentry.user_keys: unknown user attribute %q", attr))
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid logging configuration: %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func validateEncoding(key, encoding string) error {
+	switch encoding {
+	case "", encodingConsole, encodingJSON, encodingLogfmt:
+		return nil
+	default:
+		return fmt.Errorf("%s: unknown encoding %q", key, encoding)
+	}
+}
+
+// levels returns the root level, the levels of the named loggers and the
+// level at and above which stack traces are added. Without stacktrace_level,
+// stack traces are disabled by a level no entry can reach.
+func (c *Config) levels() (root zapcore.Level, modules map[string]zapcore.Level, stacktrace zapcore.Level) {
+	root, _ = parseLevel("level", c.Level, zapcore.DebugLevel)
+	modules = make(map[string]zapcore.Level, len(c.Levels))
+	for name, text := range c.Levels {
+		modules[name], _ = parseLevel("levels."+name, text, root)
+	}
+	stacktrace, _ = parseLevel("stacktrace_level", c.StacktraceLevel, zapcore.FatalLevel+1)
+	return root, modules, stacktrace
+}
+
+// buildCore turns the configuration into a tree of cores. The levels of the
+// configuration are not applied by the tree but by the core wrapping it, see
+// swapCore. fileName is the file passed to InitLog, used when no outputs are
+// configured. The returned closers release the outputs once the core is no
+// longer used. running are the closers of the running core, of which the
+// outputs that can't be opened twice are reused, see shipWriter.
+func (c *Config) buildCore(fileName string, running []io.Closer) (_ zapcore.Core, closers []io.Closer, err error) {
+	if err := c.validate(fileName); err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if err != nil {
+			closeAll(unused(closers, running))
+			closers = nil
+		}
+	}()
+
+	var cores []zapcore.Core
+	for _, o := range c.outputs(fileName) {
+		core, outputClosers, err := c.buildOutput(o, running)
+		closers = append(closers, outputClosers...)
+		if err != nil {
+			return nil, closers, err
+		}
+		cores = append(cores, core)
+	}
+	core := zapcore.NewTee(cores...)
+	if s := c.Sampling; s != nil && s.Initial > 0 {
+		tick := s.Tick
+		if tick == 0 {
+			tick = time.Second
+		}
+		core = zapcore.NewSamplerWithOptions(core, tick, s.Initial, s.Thereafter)
+	}
+
+	if s := c.Sentry; s != nil {
+		sentryCore, err := c.buildSentry()
+		if err != nil {
+			return nil, closers, err
+		}
+		core = zapcore.NewTee(core, sentryCore)
+		// Listed with the outputs so that SentryStats finds it.
+		if c, ok := sentryCore.(io.Closer); ok {
+			closers = append(closers, c)
+		}
+	}
+	// After the tee, so that sentry events and breadcrumbs have the fields
+	// too.
+	if len(c.Fields) > 0 {
+		fields := make([]zap.Field, 0, len(c.Fields))
+		for k, v := range c.Fields {
+			fields = append(fields, String(k, v))
+		}
+		core = core.With(fields)
+	}
+	return core, closers, nil
+}
+
+// buildOutput returns the core of an output, with what must be closed with
+// it in order.
+func (c *Config) buildOutput(o OutputConfig, running []io.Closer) (zapcore.Core, []io.Closer, error) {
+	level, _ := parseLevel("level", o.Level, zapcore.DebugLevel)
+
+	encoding := o.Encoding
+	if encoding == "" {
+		encoding = c.Encoding
+	}
+
+	switch o.Type {
+	case outputSyslog:
+		cfg, _ := o.Syslog.configuration("syslog")
+		w, err := logsyslog.NewWriter(cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		if c.Async != nil {
+			aw, err := c.asyncWriter(w)
+			if err != nil {
+				return nil, []io.Closer{w}, err
+			}
+			return logsyslog.NewAsyncCore(w, aw, level), []io.Closer{aw, w}, nil
+		}
+		return logsyslog.NewCore(w, level), []io.Closer{w}, nil
+	case outputJournald:
+		w, err := logjournald.NewWriter(logjournald.Configuration{
+			Path:       o.Path,
+			Identifier: o.Journald.Identifier,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		if c.Async != nil {
+			aw, err := c.asyncWriter(w)
+			if err != nil {
+				return nil, []io.Closer{w}, err
+			}
+			return logjournald.NewAsyncCore(w, aw, level), []io.Closer{aw, w}, nil
+		}
+		return logjournald.NewCore(w, level), []io.Closer{w}, nil
+	case outputShip:
+		if encoding == "" {
+			encoding = encodingJSON
+		}
+		w, err := shipWriter(o.Ship.configuration(), running)
+		if err != nil {
+			return nil, nil, err
+		}
+		// The writer is asynchronous already.
+		return zapcore.NewCore(c.encoder(encoding, nil), w, level), []io.Closer{w}, nil
+	case outputLoki:
+		if encoding == "" {
+			encoding = encodingJSON
+		}
+		cfg, _ := o.Loki.configuration("loki")
+		w, err := logloki.NewWriter(cfg)
+		if err != nil {
+			return nil, nil, err
+		}
+		return logloki.NewCore(c.encoder(encoding, nil), w, level), []io.Closer{w}, nil
+	}
+
+	var ws zapcore.WriteSyncer
+	var out io.Writer
+	var closers []io.Closer
+	switch o.Type {
+	case outputStdout:
+		ws, out = zapcore.Lock(os.Stdout), os.Stdout
+	case outputStderr:
+		ws, out = zapcore.Lock(os.Stderr), os.Stderr
+	case outputFile:
+		if encoding == "" {
+			encoding = encodingJSON
+		}
+		w, err := logrotate.NewWriter(logrotate.Configuration{
+			Filename:     o.Path,
+			MaxSize:      o.MaxSize,
+			RotationTime: o.RotationTime,
+			MaxAge:       o.MaxAge,
+			MaxBackups:   o.MaxBackups,
+			Compress:     o.Compress,
+			LocalTime:    o.LocalTime,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		ws, out, closers = w, w, []io.Closer{w}
+	}
+
+	enc := c.encoder(encoding, out)
+	if c.Async != nil {
+		w, err := c.asyncWriter(ws)
+		if err != nil {
+			return nil, closers, err
+		}
+		// The buffered entries are written before the file is closed.
+		closers = append([]io.Closer{w}, closers...)
+		return logasync.NewCore(enc, w, level), closers, nil
+	}
+	return zapcore.NewCore(enc, ws, level), closers, nil
+}
+
+// shipWriter returns the ship output of cfg among the running closers, or a
+// new one. The queue directory can only be opened once: when a running output
+// uses the same directory with another configuration, the returned output
+// has no writer until takeOver closes the running one.
+func shipWriter(cfg logship.Configuration, running []io.Closer) (*shipOutput, error) {
+	for _, c := range running {
+		o, ok := c.(*shipOutput)
+		if !ok {
+			continue
+		}
+		if reflect.DeepEqual(o.cfg, cfg) {
+			return o, nil
+		}
+		if cfg.Dir != "" && o.cfg.Dir != "" && filepath.Clean(o.cfg.Dir) == filepath.Clean(cfg.Dir) {
+			return newShipOutput(cfg, nil, o), nil
+		}
+	}
+	w, err := logship.NewWriter(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return newShipOutput(cfg, w, nil), nil
+}
+
+// shipOutput is the zapcore.WriteSyncer of a ship output. Its writer is
+// replaced when a reload hands its queue directory over to another
+// configuration.
+type shipOutput struct {
+	cfg    logship.Configuration
+	writer atomic.Value // *logship.Writer, nil once handed over
+	from   *shipOutput  // running output whose directory is taken over
+}
+
+func newShipOutput(cfg logship.Configuration, w *logship.Writer, from *shipOutput) *shipOutput {
+	o := &shipOutput{cfg: cfg, from: from}
+	o.writer.Store(w)
+	return o
+}
+
+func (o *shipOutput) load() *logship.Writer {
+	return o.writer.Load().(*logship.Writer)
+}
+
+func (o *shipOutput) Write(p []byte) (int, error) {
+	w := o.load()
+	if w == nil {
+		return 0, errShipHandedOver
+	}
+	return w.Write(p)
+}
+
+func (o *shipOutput) Sync() error {
+	if w := o.load(); w != nil {
+		return w.Sync()
+	}
+	return nil
+}
+
+func (o *shipOutput) Close() error {
+	if w := o.load(); w != nil {
+		return w.Close()
+	}
+	return nil
+}
+
+var errShipHandedOver = errors.New("ship output was handed over to a new configuration")
+
+// takeOver closes the running writer of the directory and opens the writer
+// of the new configuration, which sends the entries the old one spilled. It
+// runs while no entry is being written, so that none is written to a closed
+// writer. The running writer is reopened if the new one can't be opened.
+func (o *shipOutput) takeOver() error {
+	old := o.from.load()
+	if err := old.Close(); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "failed to close ship output: %v\n", err)
+	}
+	w, err := logship.NewWriter(o.cfg)
+	if err != nil {
+		o.from.reopen()
+		return err
+	}
+	o.from.writer.Store((*logship.Writer)(nil))
+	o.writer.Store(w)
+	return nil
+}
+
+// giveBack undoes takeOver.
+func (o *shipOutput) giveBack() {
+	if err := o.load().Close(); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "failed to close ship output: %v\n", err)
+	}
+	o.writer.Store((*logship.Writer)(nil))
+	o.from.reopen()
+}
+
+// reopen opens the writer of o again after a failed hand over. Entries are
+// dropped if it can't be opened either.
+func (o *shipOutput) reopen() {
+	w, err := logship.NewWriter(o.cfg)
+	if err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "failed to reopen ship output: %v\n", err)
+	}
+	o.writer.Store(w)
+}
+
+// takeOverShip hands the queue directories used by the running outputs over
+// to the ship outputs among closers, see shipWriter. Either every directory
+// is handed over or none is.
+func takeOverShip(closers []io.Closer) error {
+	var taken []*shipOutput
+	for _, c := range closers {
+		o, ok := c.(*shipOutput)
+		if !ok || o.from == nil {
+			continue
+		}
+		if err := o.takeOver(); err != nil {
+			for i := len(taken) - 1; i >= 0; i-- {
+				taken[i].giveBack()
+			}
+			return err
+		}
+		taken = append(taken, o)
+	}
+	for _, o := range taken {
+		o.from = nil
+	}
+	return nil
+}
+
+// asyncWriter returns the writer of logging.async writing to ws.
+func (c *Config) asyncWriter(ws zapcore.WriteSyncer) (*logasync.Writer, error) {
+	cfg, _ := c.Async.configuration()
+	return logasync.NewWriter(ws, cfg)
+}
+
+// encoder returns the encoder of the entries written to out.
+func (c *Config) encoder(encoding string, out io.Writer) zapcore.Encoder {
+	switch encoding {
+	case encodingJSON:
+		return encoder.NewJSONEncoder(getJSONEncoderConfig())
+	case encodingLogfmt:
+		// Same keys as JSON so that queries work with both.
+		return encoder.NewLogfmtEncoder(getJSONEncoderConfig())
+	default:
+		color := c.color(out)
+		var opts []encoder.ConsoleOption
+		switch c.ConsoleFormat {
+		case consoleFormatKeyValue:
+			opts = append(opts, encoder.ConsoleKeyValue(color))
+		case consoleFormatPretty:
+			opts = append(opts, encoder.ConsolePretty(color))
+		}
+		return encoder.NewConsoleEncoder(getConsoleEncoderConfig(color), opts...)
+	}
+}
+
+func (s *SyslogConfig) configuration(key string) (logsyslog.Configuration, error) {
+	cfg := logsyslog.Configuration{
+		Network:    s.Network,
+		Address:    s.Address,
+		AppName:    s.AppName,
+		Hostname:   s.Hostname,
+		Timeout:    s.Timeout,
+		MinBackoff: s.MinBackoff,
+		MaxBackoff: s.MaxBackoff,
+	}
+	if (s.Network == "") != (s.Address == "") {
+		return cfg, fmt.Errorf("%s: network and address go together", key)
+	}
+	if s.Timeout < 0 || s.MinBackoff < 0 || s.MaxBackoff < 0 {
+		return cfg, fmt.Errorf("%s: negative durations are not allowed", key)
+	}
+	if s.Format != "" {
+		if err := cfg.Format.UnmarshalText([]byte(s.Format)); err != nil {
+			return cfg, fmt.Errorf("%s.format: %w", key, err)
+		}
+	}
+	if s.Framing != "" {
+		if err := cfg.Framing.UnmarshalText([]byte(s.Framing)); err != nil {
+			return cfg, fmt.Errorf("%s.framing: %w", key, err)
+		}
+	}
+	if s.Facility != "" {
+		if err := cfg.Facility.UnmarshalText([]byte(s.Facility)); err != nil {
+			return cfg, fmt.Errorf("%s.facility: %w", key, err)
+		}
+	}
+	return cfg, nil
+}
+
+func (s *ShipConfig) validate(key string) error {
+	if s.URL == "" {
+		return fmt.Errorf("%s: ship output without url", key)
+	}
+	u, err := url.Parse(s.URL)
+	if err != nil {
+		return fmt.Errorf("%s.url: %w", key, err)
+	}
+	switch u.Scheme {
+	case "tcp", "http", "https":
+	default:
+		return fmt.Errorf("%s.url: unsupported scheme %q, use tcp, http or https", key, u.Scheme)
+	}
+	if s.BatchSize < 0 || s.BatchInterval < 0 || s.MinBackoff < 0 || s.MaxBackoff < 0 ||
+		s.Timeout < 0 || s.QueueSize < 0 || s.MaxDiskSize < 0 || s.SegmentSize < 0 {
+		return fmt.Errorf("%s: negative values are not allowed", key)
+	}
+	return nil
+}
+
+func (s *ShipConfig) configuration() logship.Configuration {
+	return logship.Configuration{
+		URL:           s.URL,
+		ContentType:   s.ContentType,
+		Headers:       s.Headers,
+		BatchSize:     s.BatchSize,
+		BatchInterval: s.BatchInterval,
+		MinBackoff:    s.MinBackoff,
+		MaxBackoff:    s.MaxBackoff,
+		Timeout:       s.Timeout,
+		QueueSize:     s.QueueSize,
+		Dir:           s.Dir,
+		MaxDiskSize:   s.MaxDiskSize,
+		SegmentSize:   s.SegmentSize,
+	}
+}
+
+func (l *LokiConfig) configuration(key string) (logloki.Configuration, error) {
+	cfg := logloki.Configuration{
+		URL:        l.URL,
+		Labels:     l.Labels,
+		TenantID:   l.TenantID,
+		Headers:    l.Headers,
+		BatchSize:  l.BatchSize,
+		BatchWait:  l.BatchWait,
+		Timeout:    l.Timeout,
+		MinBackoff: l.MinBackoff,
+		MaxBackoff: l.MaxBackoff,
+		MaxRetries: l.MaxRetries,
+		QueueSize:  l.QueueSize,
+	}
+	if l.URL == "" {
+		return cfg, fmt.Errorf("%s: loki output without url", key)
+	}
+	if l.BatchSize < 0 || l.BatchWait < 0 || l.Timeout < 0 || l.MinBackoff < 0 ||
+		l.MaxBackoff < 0 || l.MaxRetries < 0 || l.QueueSize < 0 {
+		return cfg, fmt.Errorf("%s: negative values are not allowed", key)
+	}
+	if l.Format != "" {
+		if err := cfg.Format.UnmarshalText([]byte(l.Format)); err != nil {
+			return cfg, fmt.Errorf("%s.format: %w", key, err)
+		}
+	}
+	return cfg, nil
+}
+
+func (a *AsyncConfig) configuration() (logasync.Configuration, error) {
+	cfg := logasync.Configuration{Size: a.Size, FlushInterval: a.FlushInterval}
+	if a.Policy != "" {
+		if err := cfg.Policy.UnmarshalText([]byte(a.Policy)); err != nil {
+			return cfg, fmt.Errorf("async.policy: %w", err)
+		}
+	}
+	var err error
+	cfg.DropLevel, err = parseLevel("async.drop_level", a.DropLevel, zapcore.WarnLevel)
+	return cfg, err
+}
+
+// color reports whether the level of console entries written to w is
+// coloured. By default, it is if w is a terminal, see encoder.ColorEnabled.
+func (c *Config) color(w io.Writer) bool {
+	switch c.Color {
+	case colorAlways:
+		return true
+	case colorNever:
+		return false
+	default:
+		return encoder.ColorEnabled(w)
+	}
+}
+
+func (c *Config) buildSentry() (zapcore.Core, error) {
+	s := c.Sentry
+	level, _ := parseLevel("sentry.level", s.Level, zapcore.ErrorLevel)
+	tags := s.Tags
+	if len(tags) == 0 {
+		tags = map[string]string{
+			"component": "system",
+		}
+	}
+	flushTimeout := s.FlushTimeout
+	if flushTimeout == 0 {
+		flushTimeout = time.Second * 5
+	}
+	breadcrumbLevel, _ := parseLevel("sentry.breadcrumb_level", s.BreadcrumbLevel, zapcore.DebugLevel)
+	return getSentryCore(sentry.ClientOptions{
+		Dsn:            s.DSN,
+		Environment:    s.Environment,
+		Release:        s.Release,
+		Debug:          s.Debug,
+		MaxBreadcrumbs: s.MaxBreadcrumbs,
+	}, logsentry.Configuration{
+		Level:             level,
+		Tags:              tags,
+		DisableStacktrace: s.DisableStacktrace,
+		FlushTimeout:      flushTimeout,
+		EnableBreadcrumbs: s.BreadcrumbLevel != "",
+		BreadcrumbLevel:   breadcrumbLevel,
+		InAppExclude:      s.InAppExclude,
+		TagKeys:           s.TagKeys,
+		UserKeys:          s.UserKeys,
+		ContextKeys:       s.ContextKeys,
+		FingerprintKeys:   s.FingerprintKeys,
+		RateLimit:         s.RateLimit,
+		RateBurst:         s.RateBurst,
+		DedupWindow:       s.DedupWindow,
+	})
+}
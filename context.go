@@ -0,0 +1,88 @@
+package log
+
+import (
+	"context"
+
+	"github.com/getsentry/sentry-go"
+	logsentry "github.com/liasece/log/sentry"
+	"go.uber.org/zap"
+)
+
+type contextKey struct{}
+
+// contextLogger is what ContextWithFields and ContextWithLogger store in a
+// context.
+type contextLogger struct {
+	// logger is nil to use the global logger.
+	logger *zap.Logger
+	fields []zap.Field
+}
+
+func fromContext(ctx context.Context) *contextLogger {
+	c, _ := ctx.Value(contextKey{}).(*contextLogger)
+	return c
+}
+
+// ContextWithFields returns a copy of ctx carrying fields, added by L to the
+// logger of ctx. The fields add to those already carried by ctx; a field
+// replaces any with the same key.
+//
+//	ctx = log.ContextWithFields(ctx, zap.String("request_id", id))
+//	log.L(ctx).Info("charged") // {"request_id": "..."}
+func ContextWithFields(ctx context.Context, fields ...zap.Field) context.Context {
+	if len(fields) == 0 {
+		return ctx
+	}
+	c := &contextLogger{}
+	if parent := fromContext(ctx); parent != nil {
+		c.logger = parent.logger
+		c.fields = parent.fields
+	}
+	c.fields = mergeFields(c.fields, fields)
+	return context.WithValue(ctx, contextKey{}, c)
+}
+
+// ContextWithLogger returns a copy of ctx carrying logger, returned by L
+// instead of the global logger. The fields previously carried by ctx are
+// dropped, the logger is expected to have them already; later calls to
+// ContextWithFields add to it.
+func ContextWithLogger(ctx context.Context, logger *zap.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, &contextLogger{logger: logger})
+}
+
+// contextFields returns the fields L adds for ctx besides those stored by
+// ContextWithFields: the trace of ctx and its sentry hub, if any.
+func contextFields(ctx context.Context) []zap.Field {
+	fields := traceFields(ctx)
+	if hub := sentry.GetHubFromContext(ctx); hub != nil {
+		fields = append(fields, logsentry.HubField(hub))
+	}
+	return fields
+}
+
+// mergeFields returns the fields of base whose key is not in add, followed by
+// add. base is not modified.
+func mergeFields(base, add []zap.Field) []zap.Field {
+	if len(base) == 0 {
+		return add
+	}
+	if len(add) == 0 {
+		return base
+	}
+	merged := make([]zap.Field, 0, len(base)+len(add))
+	for _, f := range base {
+		if !hasKey(add, f.Key) {
+			merged = append(merged, f)
+		}
+	}
+	return append(merged, add...)
+}
+
+func hasKey(fields []zap.Field, key string) bool {
+	for _, f := range fields {
+		if f.Key == key {
+			return true
+		}
+	}
+	return false
+}
@@ -77,6 +77,13 @@ const (
 	White
 )
 
+// Text attributes, used like colors.
+const (
+	reset Color = 0
+	bold  Color = 1
+	faint Color = 2
+)
+
 // Color represents a text color.
 type Color uint8
 
@@ -85,6 +92,12 @@ func (c Color) Add(s string) string {
 	return fmt.Sprintf("\x1b[%dm%s\x1b[0m", uint8(c), s)
 }
 
+func appendColor(buf *buffer.Buffer, c Color) {
+	buf.AppendString("\x1b[")
+	buf.AppendUint(uint64(c))
+	buf.AppendByte('m')
+}
+
 var (
 	_levelToColor = map[zapcore.Level]Color{
 		zap.DebugLevel:  White,
@@ -135,6 +148,14 @@ func MyLevelEncoder(l zapcore.Level, enc zapcore.PrimitiveArrayEncoder) {
 	enc.AppendString(MyLevelString(l))
 }
 
+// MyNameEncoder serializes a logger name in brackets, separating it from the
+// time and the caller. For example, "payments" is serialized to "[payments]".
+func MyNameEncoder(loggerName string, enc zapcore.PrimitiveArrayEncoder) {
+	enc.AppendString("[")
+	enc.AppendString(loggerName)
+	enc.AppendString("]")
+}
+
 // MyColorLevelEncoder serializes a Level to an all-caps string with color. For example,
 // InfoLevel is serialized to "\x1b[33mINFO\x1b[0m".
 func MyColorLevelEncoder(l zapcore.Level, enc zapcore.PrimitiveArrayEncoder) {
@@ -216,15 +237,65 @@ type consoleEncoder struct {
 	*jsonEncoder
 }
 
+// ConsoleOption configures the console encoder.
+type ConsoleOption func(*consoleOptions)
+
+type consoleOptions struct {
+	keyValue    bool
+	pretty      bool
+	color       bool
+	appPackages []string
+}
+
+// ConsoleKeyValue makes the console encoder write the structured context as
+// key=value pairs instead of JSON. With color, the keys are dimmed and the
+// values are coloured by type.
+func ConsoleKeyValue(color bool) ConsoleOption {
+	return func(o *consoleOptions) {
+		o.keyValue = true
+		o.color = color
+	}
+}
+
+// ConsolePretty makes the console encoder write each key=value pair of the
+// structured context on its own indented line, with the values aligned, and
+// the stack trace with the application frames highlighted.
+func ConsolePretty(color bool) ConsoleOption {
+	return func(o *consoleOptions) {
+		o.keyValue = true
+		o.pretty = true
+		o.color = color
+	}
+}
+
+// ConsoleAppPackages sets the import path prefixes of the application, whose
+// frames are highlighted by ConsolePretty. By default, every frame outside
+// GOROOT and the module cache is an application frame.
+func ConsoleAppPackages(prefixes ...string) ConsoleOption {
+	return func(o *consoleOptions) {
+		o.appPackages = append(o.appPackages, prefixes...)
+	}
+}
+
 // NewConsoleEncoder creates an encoder whose output is designed for human -
 // rather than machine - consumption. It serializes the core log entry data
 // (message, level, timestamp, etc.) in a plain-text format and leaves the
-// structured context as JSON.
+// structured context as JSON, or as key=value pairs with ConsoleKeyValue or
+// ConsolePretty.
 //
 // Note that although the console encoder doesn't use the keys specified in the
 // encoder configuration, it will omit any element whose key is set to the empty
 // string.
-func NewConsoleEncoder(cfg zapcore.EncoderConfig) zapcore.Encoder {
+func NewConsoleEncoder(cfg zapcore.EncoderConfig, opts ...ConsoleOption) zapcore.Encoder {
+	o := &consoleOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	if o.keyValue {
+		enc := NewLogfmtEncoder(cfg).(*logfmtEncoder)
+		enc.style = logfmtStyle{color: o.color, aligned: o.pretty}
+		return consoleKVEncoder{enc, o}
+	}
 	return consoleEncoder{newJSONEncoder(cfg, true)}
 }
 
@@ -234,7 +305,28 @@ func (c consoleEncoder) Clone() zapcore.Encoder {
 
 func (c consoleEncoder) EncodeEntry(ent zapcore.Entry, fields []zap.Field) (*buffer.Buffer, error) {
 	line := GetBuffer()
+	writeConsoleHeader(line, c.EncoderConfig, ent)
+
+	// Add any structured context.
+	c.writeContext(line, fields)
+
+	// If there's no stacktrace key, honor that; this allows users to force
+	// single-line output.
+	if ent.Stack != "" && c.StacktraceKey != "" {
+		line.AppendByte('\n')
+		line.AppendString(ent.Stack)
+	}
+
+	if c.LineEnding != "" {
+		line.AppendString(c.LineEnding)
+	} else {
+		line.AppendString(zapcore.DefaultLineEnding)
+	}
+	return line, nil
+}
 
+// writeConsoleHeader writes the entry's metadata and message in plain text.
+func writeConsoleHeader(line *buffer.Buffer, c *zapcore.EncoderConfig, ent zapcore.Entry) {
 	// We don't want the entry's metadata to be quoted and escaped (if it's
 	// encoded as strings), which means that we can't use the JSON encoder. The
 	// simplest option is to use the memory encoder and fmt.Fprint.
@@ -268,26 +360,9 @@ func (c consoleEncoder) EncodeEntry(ent zapcore.Entry, fields []zap.Field) (*buf
 
 	// Add the message itself.
 	if c.MessageKey != "" {
-		c.addTabIfNecessary(line)
+		addTabIfNecessary(line)
 		line.AppendString(ent.Message)
 	}
-
-	// Add any structured context.
-	c.writeContext(line, fields)
-
-	// If there's no stacktrace key, honor that; this allows users to force
-	// single-line output.
-	if ent.Stack != "" && c.StacktraceKey != "" {
-		line.AppendByte('\n')
-		line.AppendString(ent.Stack)
-	}
-
-	if c.LineEnding != "" {
-		line.AppendString(c.LineEnding)
-	} else {
-		line.AppendString(zapcore.DefaultLineEnding)
-	}
-	return line, nil
 }
 
 func (c consoleEncoder) writeContext(line *buffer.Buffer, extra []zap.Field) {
@@ -300,13 +375,13 @@ func (c consoleEncoder) writeContext(line *buffer.Buffer, extra []zap.Field) {
 		return
 	}
 
-	c.addTabIfNecessary(line)
+	addTabIfNecessary(line)
 	line.AppendByte('{')
 	_, _ = line.Write(context.buf.Bytes())
 	line.AppendByte('}')
 }
 
-func (c consoleEncoder) addTabIfNecessary(line *buffer.Buffer) {
+func addTabIfNecessary(line *buffer.Buffer) {
 	if line.Len() > 0 {
 		line.AppendByte(' ')
 	}
@@ -317,3 +392,130 @@ func addFields(enc zapcore.ObjectEncoder, fields []zap.Field) {
 		fields[i].AddTo(enc)
 	}
 }
+
+// consoleKVEncoder is the console encoder writing the structured context as
+// key=value pairs.
+type consoleKVEncoder struct {
+	*logfmtEncoder
+	opts *consoleOptions
+}
+
+func (c consoleKVEncoder) Clone() zapcore.Encoder {
+	return consoleKVEncoder{c.logfmtEncoder.Clone().(*logfmtEncoder), c.opts}
+}
+
+func (c consoleKVEncoder) EncodeEntry(ent zapcore.Entry, fields []zap.Field) (*buffer.Buffer, error) {
+	line := GetBuffer()
+	writeConsoleHeader(line, c.EncoderConfig, ent)
+
+	context := c.logfmtEncoder.Clone().(*logfmtEncoder)
+	addFields(context, fields)
+	if context.buf.Len() > 0 {
+		if c.opts.pretty {
+			writeAlignedPairs(line, context)
+		} else {
+			addTabIfNecessary(line)
+			_, _ = line.Write(context.buf.Bytes())
+		}
+	}
+	context.buf.Free()
+	putLogfmtEncoder(context)
+
+	if ent.Stack != "" && c.StacktraceKey != "" {
+		if c.opts.pretty {
+			c.writePrettyStack(line, ent.Stack)
+		} else {
+			line.AppendByte('\n')
+			line.AppendString(ent.Stack)
+		}
+	}
+
+	if c.LineEnding != "" {
+		line.AppendString(c.LineEnding)
+	} else {
+		line.AppendString(zapcore.DefaultLineEnding)
+	}
+	return line, nil
+}
+
+const _prettyIndent = "    "
+
+// writeAlignedPairs writes the pairs recorded by an aligned encoder one per
+// line, padding the keys to the longest one.
+func writeAlignedPairs(line *buffer.Buffer, enc *logfmtEncoder) {
+	width := 0
+	for _, p := range enc.pairs {
+		if p.width > width {
+			width = p.width
+		}
+	}
+	b := enc.buf.Bytes()
+	for i, p := range enc.pairs {
+		end := len(b)
+		if i+1 < len(enc.pairs) {
+			end = enc.pairs[i+1].start
+		}
+		line.AppendByte('\n')
+		line.AppendString(_prettyIndent)
+		_, _ = line.Write(b[p.start:p.keyEnd])
+		for n := p.width; n < width; n++ {
+			line.AppendByte(' ')
+		}
+		_, _ = line.Write(b[p.keyEnd:end])
+	}
+}
+
+// writePrettyStack writes a stack trace taken by zap, made of a function line
+// followed by a tab-indented file:line, with the application frames marked
+// and, with colours, highlighted.
+func (c consoleKVEncoder) writePrettyStack(line *buffer.Buffer, stack string) {
+	lines := strings.Split(stack, "\n")
+	for i := 0; i < len(lines); i += 2 {
+		function := lines[i]
+		var file string
+		if i+1 < len(lines) {
+			file = strings.TrimLeft(lines[i+1], "\t")
+		}
+		app := c.opts.isAppFrame(function, file)
+
+		line.AppendByte('\n')
+		line.AppendString(_prettyIndent)
+		if app {
+			line.AppendString("> ")
+		} else {
+			line.AppendString("  ")
+		}
+		if c.opts.color {
+			if app {
+				appendColor(line, bold)
+			} else {
+				appendColor(line, faint)
+			}
+		}
+		line.AppendString(function)
+		if file != "" {
+			line.AppendByte('\n')
+			line.AppendString(_prettyIndent)
+			line.AppendString("    ")
+			line.AppendString(file)
+		}
+		if c.opts.color {
+			appendColor(line, reset)
+		}
+	}
+}
+
+func (o *consoleOptions) isAppFrame(function, file string) bool {
+	if len(o.appPackages) > 0 {
+		for _, p := range o.appPackages {
+			if strings.HasPrefix(function, p) {
+				return true
+			}
+		}
+		return false
+	}
+	if goroot := runtime.GOROOT(); goroot != "" && strings.HasPrefix(file, goroot) {
+		return false
+	}
+	return !strings.Contains(file, "/pkg/mod/") && !strings.Contains(file, "/vendor/")
+}
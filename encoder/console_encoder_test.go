@@ -0,0 +1,146 @@
+package encoder
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const testStack = "example.com/app/db.Query\n" +
+	"\t/src/app/db/db.go:12\n" +
+	"runtime.goexit\n" +
+	"\t/usr/local/go/src/runtime/asm_amd64.s:1371"
+
+func encodeConsole(t *testing.T, ent zapcore.Entry, fields []zap.Field, opts ...ConsoleOption) string {
+	t.Helper()
+	cfg := testEncoderConfig()
+	cfg.TimeKey, cfg.LevelKey = "", ""
+	buf, err := NewConsoleEncoder(cfg, opts...).EncodeEntry(ent, fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer buf.Free()
+	return buf.String()
+}
+
+func TestConsoleEncoderKeyValue(t *testing.T) {
+	ent := zapcore.Entry{Level: zap.ErrorLevel, Time: testTime, Message: "failed", Stack: testStack}
+	fields := []zap.Field{
+		zap.String("user", "ann"),
+		zap.Int("attempts", 3),
+		zap.Bool("ok", false),
+		zap.Duration("took", 1500*time.Millisecond),
+		zap.Error(errors.New("boom")),
+		zap.String("errand", "milk"),
+		zap.String("error_budget", "5%"),
+	}
+
+	tests := []struct {
+		name string
+		opts []ConsoleOption
+		want string
+	}{
+		{
+			"key value",
+			[]ConsoleOption{ConsoleKeyValue(false)},
+			"failed user=ann attempts=3 ok=false took=1.5s error=boom errand=milk error_budget=5%\n" +
+				testStack + "\n",
+		},
+		{
+			"key value color",
+			[]ConsoleOption{ConsoleKeyValue(true)},
+			"failed \x1b[2muser=\x1b[0mann \x1b[2mattempts=\x1b[0m\x1b[36m3\x1b[0m" +
+				" \x1b[2mok=\x1b[0m\x1b[33mfalse\x1b[0m \x1b[2mtook=\x1b[0m\x1b[35m1.5s\x1b[0m" +
+				" \x1b[2merror=\x1b[0m\x1b[31mboom\x1b[0m \x1b[2merrand=\x1b[0mmilk" +
+				" \x1b[2merror_budget=\x1b[0m5%\n" +
+				testStack + "\n",
+		},
+		{
+			"pretty",
+			[]ConsoleOption{ConsolePretty(false), ConsoleAppPackages("example.com/app")},
+			"failed\n" +
+				"    user        =ann\n" +
+				"    attempts    =3\n" +
+				"    ok          =false\n" +
+				"    took        =1.5s\n" +
+				"    error       =boom\n" +
+				"    errand      =milk\n" +
+				"    error_budget=5%\n" +
+				"    > example.com/app/db.Query\n" +
+				"        /src/app/db/db.go:12\n" +
+				"      runtime.goexit\n" +
+				"        /usr/local/go/src/runtime/asm_amd64.s:1371\n",
+		},
+		{
+			"pretty color",
+			[]ConsoleOption{ConsolePretty(true), ConsoleAppPackages("example.com/app")},
+			"failed\n" +
+				"    \x1b[2muser        =\x1b[0mann\n" +
+				"    \x1b[2mattempts    =\x1b[0m\x1b[36m3\x1b[0m\n" +
+				"    \x1b[2mok          =\x1b[0m\x1b[33mfalse\x1b[0m\n" +
+				"    \x1b[2mtook        =\x1b[0m\x1b[35m1.5s\x1b[0m\n" +
+				"    \x1b[2merror       =\x1b[0m\x1b[31mboom\x1b[0m\n" +
+				"    \x1b[2merrand      =\x1b[0mmilk\n" +
+				"    \x1b[2merror_budget=\x1b[0m5%\n" +
+				"    > \x1b[1mexample.com/app/db.Query\n" +
+				"        /src/app/db/db.go:12\x1b[0m\n" +
+				"      \x1b[2mruntime.goexit\n" +
+				"        /usr/local/go/src/runtime/asm_amd64.s:1371\x1b[0m\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := encodeConsole(t, ent, fields, tt.opts...); got != tt.want {
+				t.Errorf("got\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConsoleEncoderPrettyNamespace(t *testing.T) {
+	ent := zapcore.Entry{Message: "req"}
+	fields := []zap.Field{zap.String("id", "x"), zap.Namespace("http"), zap.Int("status", 200)}
+	want := "req\n" +
+		"    id         =x\n" +
+		"    http.status=200\n"
+	if got := encodeConsole(t, ent, fields, ConsolePretty(false)); got != want {
+		t.Errorf("got\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestConsoleEncoderWith(t *testing.T) {
+	cfg := testEncoderConfig()
+	cfg.TimeKey, cfg.LevelKey = "", ""
+	enc := NewConsoleEncoder(cfg, ConsoleKeyValue(false))
+	zap.String("service", "api").AddTo(enc)
+	buf, err := enc.EncodeEntry(zapcore.Entry{Message: "hi"}, []zap.Field{zap.Int("n", 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer buf.Free()
+	if got, want := buf.String(), "hi service=api n=1\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestIsErrorKey(t *testing.T) {
+	for key, want := range map[string]bool{
+		"error":          true,
+		"errorVerbose":   true,
+		"err":            true,
+		"dbError":        true,
+		"dbErrorVerbose": true,
+		"parse_error":    true,
+		"errand":         false,
+		"error_budget":   false,
+		"errors":         false,
+		"terror":         false,
+	} {
+		if got := isErrorKey(key); got != want {
+			t.Errorf("isErrorKey(%q) = %v, want %v", key, got, want)
+		}
+	}
+}
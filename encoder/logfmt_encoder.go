@@ -0,0 +1,639 @@
+package encoder
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"math"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+)
+
+var _logfmtPool = sync.Pool{New: func() interface{} {
+	return &logfmtEncoder{}
+}}
+
+func getLogfmtEncoder() *logfmtEncoder {
+	return _logfmtPool.Get().(*logfmtEncoder)
+}
+
+func putLogfmtEncoder(enc *logfmtEncoder) {
+	enc.EncoderConfig = nil
+	enc.buf = nil
+	enc.namespace = ""
+	enc.style = logfmtStyle{}
+	enc.pairs = enc.pairs[:0]
+	_logfmtPool.Put(enc)
+}
+
+// logfmtEncoder writes fields as space separated key=value pairs.
+type logfmtEncoder struct {
+	*zapcore.EncoderConfig
+	buf *buffer.Buffer
+	// namespace is the dotted prefix of the keys, ending with a dot, made of
+	// the open namespaces and the objects being encoded.
+	namespace string
+
+	style logfmtStyle
+	pairs []logfmtPair
+}
+
+// logfmtStyle makes the logfmt encoder write for humans, see the console
+// encoder options.
+type logfmtStyle struct {
+	// color dims the keys and colours the values by type.
+	color bool
+	// aligned records where each pair starts instead of separating them, so
+	// that they can be laid out one per line with aligned values.
+	aligned bool
+}
+
+// logfmtPair is the position of a pair in the buffer of an aligned encoder.
+// The pair ends where the next one starts.
+type logfmtPair struct {
+	start  int
+	keyEnd int
+	width  int
+}
+
+// NewLogfmtEncoder creates an encoder that writes entries as logfmt lines:
+//
+//	ts=2021-02-03T04:05:06.789Z level=info caller=app/main.go:12 msg="user created" user.id=42 tags=[a,b]
+//
+// Objects and namespaces are flattened into dotted keys, arrays are written
+// as [a,b,c] and values are quoted when they contain spaces, quotes, equal
+// signs or control characters. Elements of arrays are also quoted when they
+// contain commas, brackets or braces. Errors are written like the JSON encoder does,
+// under the key, key+"Verbose" and key+"Causes". Binary values are base64
+// encoded.
+func NewLogfmtEncoder(cfg zapcore.EncoderConfig) zapcore.Encoder {
+	return &logfmtEncoder{
+		EncoderConfig: &cfg,
+		buf:           GetBuffer(),
+	}
+}
+
+func (enc *logfmtEncoder) AddArray(key string, arr zapcore.ArrayMarshaler) error {
+	enc.addKey(key)
+	value := getLogfmtArrayEncoder(enc.EncoderConfig, true)
+	defer putLogfmtArrayEncoder(value)
+	value.buf.AppendByte('[')
+	err := arr.MarshalLogArray(value)
+	value.buf.AppendByte(']')
+	enc.appendValue(value.buf.Bytes())
+	return err
+}
+
+func (enc *logfmtEncoder) AddObject(key string, obj zapcore.ObjectMarshaler) error {
+	namespace := enc.namespace
+	enc.namespace = namespace + key + "."
+	err := obj.MarshalLogObject(enc)
+	enc.namespace = namespace
+	return err
+}
+
+func (enc *logfmtEncoder) AddBinary(key string, val []byte) {
+	enc.AddString(key, base64.StdEncoding.EncodeToString(val))
+}
+
+func (enc *logfmtEncoder) AddByteString(key string, val []byte) {
+	enc.addKey(key)
+	enc.appendValue(val)
+}
+
+func (enc *logfmtEncoder) AddBool(key string, val bool) {
+	enc.addKey(key)
+	enc.startValue(Yellow)
+	enc.buf.AppendBool(val)
+	enc.endValue()
+}
+
+func (enc *logfmtEncoder) AddComplex128(key string, val complex128) {
+	enc.addKey(key)
+	enc.startValue(Cyan)
+	appendComplex(enc.buf, val)
+	enc.endValue()
+}
+
+func (enc *logfmtEncoder) AddDuration(key string, val time.Duration) {
+	enc.addKey(key)
+	enc.startValue(Magenta)
+	defer enc.endValue()
+	value := getLogfmtArrayEncoder(enc.EncoderConfig, false)
+	defer putLogfmtArrayEncoder(value)
+	if enc.EncodeDuration != nil {
+		enc.EncodeDuration(val, value)
+	}
+	if value.buf.Len() == 0 {
+		// User-supplied EncodeDuration is a no-op. Fall back to nanoseconds.
+		value.AppendInt64(int64(val))
+	}
+	enc.appendValue(value.buf.Bytes())
+}
+
+func (enc *logfmtEncoder) AddFloat64(key string, val float64) {
+	enc.addKey(key)
+	enc.startValue(Cyan)
+	appendFloat(enc.buf, val, 64)
+	enc.endValue()
+}
+
+func (enc *logfmtEncoder) AddFloat32(key string, val float32) {
+	enc.addKey(key)
+	enc.startValue(Cyan)
+	appendFloat(enc.buf, float64(val), 32)
+	enc.endValue()
+}
+
+func (enc *logfmtEncoder) AddInt64(key string, val int64) {
+	enc.addKey(key)
+	enc.startValue(Cyan)
+	enc.buf.AppendInt(val)
+	enc.endValue()
+}
+
+func (enc *logfmtEncoder) AddReflected(key string, obj interface{}) error {
+	b, err := json.Marshal(obj)
+	if err != nil {
+		return err
+	}
+	enc.addKey(key)
+	enc.startValue(Blue)
+	enc.appendValue(b)
+	enc.endValue()
+	return nil
+}
+
+func (enc *logfmtEncoder) OpenNamespace(key string) {
+	enc.namespace += key + "."
+}
+
+func (enc *logfmtEncoder) AddString(key, val string) {
+	enc.addKey(key)
+	if isErrorKey(key) {
+		enc.startValue(Red)
+		defer enc.endValue()
+	}
+	enc.appendStringValue(val)
+}
+
+func (enc *logfmtEncoder) AddTime(key string, val time.Time) {
+	enc.addKey(key)
+	enc.startValue(Magenta)
+	defer enc.endValue()
+	value := getLogfmtArrayEncoder(enc.EncoderConfig, false)
+	defer putLogfmtArrayEncoder(value)
+	if enc.EncodeTime != nil {
+		enc.EncodeTime(val, value)
+	}
+	if value.buf.Len() == 0 {
+		// User-supplied EncodeTime is a no-op. Fall back to RFC 3339.
+		value.buf.AppendTime(val, time.RFC3339Nano)
+	}
+	enc.appendValue(value.buf.Bytes())
+}
+
+func (enc *logfmtEncoder) AddUint64(key string, val uint64) {
+	enc.addKey(key)
+	enc.startValue(Cyan)
+	enc.buf.AppendUint(val)
+	enc.endValue()
+}
+
+func (enc *logfmtEncoder) AddComplex64(k string, v complex64) { enc.AddComplex128(k, complex128(v)) }
+func (enc *logfmtEncoder) AddInt(k string, v int)             { enc.AddInt64(k, int64(v)) }
+func (enc *logfmtEncoder) AddInt32(k string, v int32)         { enc.AddInt64(k, int64(v)) }
+func (enc *logfmtEncoder) AddInt16(k string, v int16)         { enc.AddInt64(k, int64(v)) }
+func (enc *logfmtEncoder) AddInt8(k string, v int8)           { enc.AddInt64(k, int64(v)) }
+func (enc *logfmtEncoder) AddUint(k string, v uint)           { enc.AddUint64(k, uint64(v)) }
+func (enc *logfmtEncoder) AddUint32(k string, v uint32)       { enc.AddUint64(k, uint64(v)) }
+func (enc *logfmtEncoder) AddUint16(k string, v uint16)       { enc.AddUint64(k, uint64(v)) }
+func (enc *logfmtEncoder) AddUint8(k string, v uint8)         { enc.AddUint64(k, uint64(v)) }
+func (enc *logfmtEncoder) AddUintptr(k string, v uintptr)     { enc.AddUint64(k, uint64(v)) }
+
+func (enc *logfmtEncoder) Clone() zapcore.Encoder {
+	clone := enc.clone()
+	_, _ = clone.buf.Write(enc.buf.Bytes())
+	clone.pairs = append(clone.pairs, enc.pairs...)
+	return clone
+}
+
+func (enc *logfmtEncoder) clone() *logfmtEncoder {
+	clone := getLogfmtEncoder()
+	clone.EncoderConfig = enc.EncoderConfig
+	clone.namespace = enc.namespace
+	clone.style = enc.style
+	clone.buf = GetBuffer()
+	return clone
+}
+
+func (enc *logfmtEncoder) EncodeEntry(ent zapcore.Entry, fields []zap.Field) (*buffer.Buffer, error) {
+	final := enc.clone()
+	// The entry metadata is never namespaced.
+	final.namespace = ""
+
+	if final.TimeKey != "" {
+		final.AddTime(final.TimeKey, ent.Time)
+	}
+	if final.LevelKey != "" {
+		final.addKey(final.LevelKey)
+		value := getLogfmtArrayEncoder(final.EncoderConfig, false)
+		if final.EncodeLevel != nil {
+			final.EncodeLevel(ent.Level, value)
+		}
+		if value.buf.Len() == 0 {
+			value.AppendString(ent.Level.String())
+		}
+		final.appendValue(value.buf.Bytes())
+		putLogfmtArrayEncoder(value)
+	}
+	if ent.LoggerName != "" && final.NameKey != "" {
+		final.addKey(final.NameKey)
+		value := getLogfmtArrayEncoder(final.EncoderConfig, false)
+		if final.EncodeName != nil {
+			final.EncodeName(ent.LoggerName, value)
+		}
+		if value.buf.Len() == 0 {
+			value.AppendString(ent.LoggerName)
+		}
+		final.appendValue(value.buf.Bytes())
+		putLogfmtArrayEncoder(value)
+	}
+	if ent.Caller.Defined && final.CallerKey != "" {
+		final.addKey(final.CallerKey)
+		value := getLogfmtArrayEncoder(final.EncoderConfig, false)
+		if final.EncodeCaller != nil {
+			final.EncodeCaller(ent.Caller, value)
+		}
+		if value.buf.Len() == 0 {
+			value.AppendString(ent.Caller.String())
+		}
+		final.appendValue(value.buf.Bytes())
+		putLogfmtArrayEncoder(value)
+	}
+	if final.MessageKey != "" {
+		final.AddString(final.MessageKey, ent.Message)
+	}
+	if enc.buf.Len() > 0 {
+		if final.buf.Len() > 0 {
+			final.buf.AppendByte(' ')
+		}
+		_, _ = final.buf.Write(enc.buf.Bytes())
+	}
+	final.namespace = enc.namespace
+	addFields(final, fields)
+	final.namespace = ""
+	if ent.Stack != "" && final.StacktraceKey != "" {
+		final.AddString(final.StacktraceKey, ent.Stack)
+	}
+	if final.LineEnding != "" {
+		final.buf.AppendString(final.LineEnding)
+	} else {
+		final.buf.AppendString(zapcore.DefaultLineEnding)
+	}
+
+	ret := final.buf
+	putLogfmtEncoder(final)
+	return ret, nil
+}
+
+// addKey writes the separator and the namespaced key. Characters that would
+// break the pair apart are replaced by underscores.
+func (enc *logfmtEncoder) addKey(key string) {
+	if enc.style.aligned {
+		enc.pairs = append(enc.pairs, logfmtPair{start: enc.buf.Len(), width: len(enc.namespace) + len(key)})
+	} else if enc.buf.Len() > 0 {
+		enc.buf.AppendByte(' ')
+	}
+	if enc.style.color {
+		appendColor(enc.buf, faint)
+	}
+	appendLogfmtKey(enc.buf, enc.namespace)
+	appendLogfmtKey(enc.buf, key)
+	if enc.style.aligned {
+		enc.pairs[len(enc.pairs)-1].keyEnd = enc.buf.Len()
+	}
+	enc.buf.AppendByte('=')
+	if enc.style.color {
+		appendColor(enc.buf, reset)
+	}
+}
+
+// startValue colours the value about to be written, if colours are enabled.
+func (enc *logfmtEncoder) startValue(c Color) {
+	if enc.style.color {
+		appendColor(enc.buf, c)
+	}
+}
+
+func (enc *logfmtEncoder) endValue() {
+	if enc.style.color {
+		appendColor(enc.buf, reset)
+	}
+}
+
+// isErrorKey reports whether key holds an error written by zap, like "error",
+// "errorVerbose" or "dbError", but not "errand" or "error_budget".
+func isErrorKey(key string) bool {
+	key = strings.TrimSuffix(key, "Verbose")
+	return key == "error" || key == "err" ||
+		strings.HasSuffix(key, "Error") || strings.HasSuffix(key, "_error")
+}
+
+func appendLogfmtKey(buf *buffer.Buffer, key string) {
+	for i := 0; i < len(key); i++ {
+		b := key[i]
+		if b <= ' ' || b == '=' || b == '"' || b == utf8.RuneSelf-1 {
+			buf.AppendByte('_')
+			continue
+		}
+		buf.AppendByte(b)
+	}
+}
+
+func (enc *logfmtEncoder) appendStringValue(val string) {
+	if !logfmtNeedsQuote(val) {
+		enc.buf.AppendString(val)
+		return
+	}
+	appendLogfmtQuoted(enc.buf, val)
+}
+
+func appendLogfmtQuoted(buf *buffer.Buffer, val string) {
+	buf.AppendByte('"')
+	for i := 0; i < len(val); {
+		if tryAddLogfmtRuneSelf(buf, val[i]) {
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(val[i:])
+		if r == utf8.RuneError && size == 1 {
+			buf.AppendString("\ufffd")
+			i++
+			continue
+		}
+		buf.AppendString(val[i : i+size])
+		i += size
+	}
+	buf.AppendByte('"')
+}
+
+// appendValue writes val, quoted if necessary.
+func (enc *logfmtEncoder) appendValue(val []byte) {
+	if !logfmtNeedsQuote(string(val)) {
+		_, _ = enc.buf.Write(val)
+		return
+	}
+	enc.appendStringValue(string(val))
+}
+
+func logfmtNeedsQuote(s string) bool {
+	if s == "" {
+		return true
+	}
+	for i := 0; i < len(s); i++ {
+		b := s[i]
+		if b <= ' ' || b == '=' || b == '"' || b == '\\' || b == utf8.RuneSelf-1 {
+			return true
+		}
+		if b >= utf8.RuneSelf {
+			r, size := utf8.DecodeRuneInString(s[i:])
+			if r == utf8.RuneError && size == 1 {
+				return true
+			}
+			i += size - 1
+		}
+	}
+	return false
+}
+
+// logfmtElementNeedsQuote is logfmtNeedsQuote for the elements of an array,
+// which are also quoted when they contain the separators of arrays and
+// objects.
+func logfmtElementNeedsQuote(s string) bool {
+	return logfmtNeedsQuote(s) || strings.ContainsAny(s, ",[]{}")
+}
+
+// tryAddLogfmtRuneSelf appends b, escaped for a quoted value, if it is a
+// single byte character.
+func tryAddLogfmtRuneSelf(buf *buffer.Buffer, b byte) bool {
+	if b >= utf8.RuneSelf {
+		return false
+	}
+	if 0x20 <= b && b != '\\' && b != '"' && b != utf8.RuneSelf-1 {
+		buf.AppendByte(b)
+		return true
+	}
+	switch b {
+	case '\\', '"':
+		buf.AppendByte('\\')
+		buf.AppendByte(b)
+	case '\n':
+		buf.AppendString(`\n`)
+	case '\r':
+		buf.AppendString(`\r`)
+	case '\t':
+		buf.AppendString(`\t`)
+	default:
+		buf.AppendString(`\u00`)
+		buf.AppendByte(_hex[b>>4])
+		buf.AppendByte(_hex[b&0xF])
+	}
+	return true
+}
+
+func appendFloat(buf *buffer.Buffer, val float64, bitSize int) {
+	switch {
+	case math.IsNaN(val):
+		buf.AppendString("NaN")
+	case math.IsInf(val, 1):
+		buf.AppendString("+Inf")
+	case math.IsInf(val, -1):
+		buf.AppendString("-Inf")
+	default:
+		buf.AppendFloat(val, bitSize)
+	}
+}
+
+func appendComplex(buf *buffer.Buffer, val complex128) {
+	r, i := float64(real(val)), float64(imag(val))
+	buf.AppendFloat(r, 64)
+	if i >= 0 {
+		buf.AppendByte('+')
+	}
+	buf.AppendFloat(i, 64)
+	buf.AppendByte('i')
+}
+
+var _logfmtArrayPool = sync.Pool{New: func() interface{} {
+	return &logfmtArrayEncoder{}
+}}
+
+func getLogfmtArrayEncoder(cfg *zapcore.EncoderConfig, separated bool) *logfmtArrayEncoder {
+	enc := _logfmtArrayPool.Get().(*logfmtArrayEncoder)
+	enc.cfg = cfg
+	enc.buf = GetBuffer()
+	enc.separated = separated
+	enc.started = false
+	return enc
+}
+
+func putLogfmtArrayEncoder(enc *logfmtArrayEncoder) {
+	enc.buf.Free()
+	enc.cfg = nil
+	enc.buf = nil
+	_logfmtArrayPool.Put(enc)
+}
+
+// logfmtArrayEncoder writes the unquoted text of a value. Elements of arrays
+// are separated by commas; the pieces written by the level, time, caller and
+// name encoders are simply concatenated, as are those of a time or duration
+// within an array.
+type logfmtArrayEncoder struct {
+	cfg       *zapcore.EncoderConfig
+	buf       *buffer.Buffer
+	separated bool
+	// started is true once an element of the current array is written.
+	started bool
+}
+
+func (enc *logfmtArrayEncoder) addSeparator() {
+	if !enc.separated {
+		return
+	}
+	if enc.started {
+		enc.buf.AppendByte(',')
+	}
+	enc.started = true
+}
+
+// appendPieces runs encode, which may append several pieces, as one
+// element.
+func (enc *logfmtArrayEncoder) appendPieces(encode func()) {
+	enc.addSeparator()
+	separated := enc.separated
+	enc.separated = false
+	encode()
+	enc.separated = separated
+}
+
+func (enc *logfmtArrayEncoder) AppendArray(arr zapcore.ArrayMarshaler) error {
+	enc.addSeparator()
+	enc.buf.AppendByte('[')
+	separated, started := enc.separated, enc.started
+	enc.separated, enc.started = true, false
+	err := arr.MarshalLogArray(enc)
+	enc.separated, enc.started = separated, started
+	enc.buf.AppendByte(']')
+	return err
+}
+
+func (enc *logfmtArrayEncoder) AppendObject(obj zapcore.ObjectMarshaler) error {
+	enc.addSeparator()
+	enc.buf.AppendByte('{')
+	// Write the fields as key=value pairs right into our buffer.
+	inner := getLogfmtEncoder()
+	inner.EncoderConfig = enc.cfg
+	inner.buf = GetBuffer()
+	err := obj.MarshalLogObject(inner)
+	_, _ = enc.buf.Write(inner.buf.Bytes())
+	inner.buf.Free()
+	putLogfmtEncoder(inner)
+	enc.buf.AppendByte('}')
+	return err
+}
+
+func (enc *logfmtArrayEncoder) AppendReflected(val interface{}) error {
+	b, err := json.Marshal(val)
+	if err != nil {
+		return err
+	}
+	enc.addSeparator()
+	_, _ = enc.buf.Write(b)
+	return nil
+}
+
+func (enc *logfmtArrayEncoder) AppendBool(v bool) {
+	enc.addSeparator()
+	enc.buf.AppendBool(v)
+}
+
+func (enc *logfmtArrayEncoder) AppendByteString(v []byte) {
+	enc.AppendString(string(v))
+}
+
+func (enc *logfmtArrayEncoder) AppendComplex128(v complex128) {
+	enc.addSeparator()
+	appendComplex(enc.buf, v)
+}
+
+func (enc *logfmtArrayEncoder) AppendDuration(v time.Duration) {
+	enc.appendPieces(func() {
+		cur := enc.buf.Len()
+		if enc.cfg != nil && enc.cfg.EncodeDuration != nil {
+			enc.cfg.EncodeDuration(v, enc)
+		}
+		if cur == enc.buf.Len() {
+			enc.buf.AppendInt(int64(v))
+		}
+	})
+}
+
+func (enc *logfmtArrayEncoder) AppendFloat64(v float64) {
+	enc.addSeparator()
+	appendFloat(enc.buf, v, 64)
+}
+
+func (enc *logfmtArrayEncoder) AppendFloat32(v float32) {
+	enc.addSeparator()
+	appendFloat(enc.buf, float64(v), 32)
+}
+
+func (enc *logfmtArrayEncoder) AppendInt64(v int64) {
+	enc.addSeparator()
+	enc.buf.AppendInt(v)
+}
+
+// AppendString writes an element quoted as needed, but the pieces of a
+// level, time or caller as they are.
+func (enc *logfmtArrayEncoder) AppendString(v string) {
+	enc.addSeparator()
+	if enc.separated && logfmtElementNeedsQuote(v) {
+		appendLogfmtQuoted(enc.buf, v)
+		return
+	}
+	enc.buf.AppendString(v)
+}
+
+func (enc *logfmtArrayEncoder) AppendTime(v time.Time) {
+	enc.appendPieces(func() {
+		cur := enc.buf.Len()
+		if enc.cfg != nil && enc.cfg.EncodeTime != nil {
+			enc.cfg.EncodeTime(v, enc)
+		}
+		if cur == enc.buf.Len() {
+			enc.buf.AppendTime(v, time.RFC3339Nano)
+		}
+	})
+}
+
+func (enc *logfmtArrayEncoder) AppendUint64(v uint64) {
+	enc.addSeparator()
+	enc.buf.AppendUint(v)
+}
+
+func (enc *logfmtArrayEncoder) AppendComplex64(v complex64) { enc.AppendComplex128(complex128(v)) }
+func (enc *logfmtArrayEncoder) AppendInt(v int)             { enc.AppendInt64(int64(v)) }
+func (enc *logfmtArrayEncoder) AppendInt32(v int32)         { enc.AppendInt64(int64(v)) }
+func (enc *logfmtArrayEncoder) AppendInt16(v int16)         { enc.AppendInt64(int64(v)) }
+func (enc *logfmtArrayEncoder) AppendInt8(v int8)           { enc.AppendInt64(int64(v)) }
+func (enc *logfmtArrayEncoder) AppendUint(v uint)           { enc.AppendUint64(uint64(v)) }
+func (enc *logfmtArrayEncoder) AppendUint32(v uint32)       { enc.AppendUint64(uint64(v)) }
+func (enc *logfmtArrayEncoder) AppendUint16(v uint16)       { enc.AppendUint64(uint64(v)) }
+func (enc *logfmtArrayEncoder) AppendUint8(v uint8)         { enc.AppendUint64(uint64(v)) }
+func (enc *logfmtArrayEncoder) AppendUintptr(v uintptr)     { enc.AppendUint64(uint64(v)) }
@@ -0,0 +1,177 @@
+package encoder
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+var testTime = time.Date(2021, 2, 3, 4, 5, 6, 789e6, time.UTC)
+
+func testEncoderConfig() zapcore.EncoderConfig {
+	return zapcore.EncoderConfig{
+		MessageKey:     "msg",
+		LevelKey:       "level",
+		TimeKey:        "ts",
+		NameKey:        "logger",
+		CallerKey:      "caller",
+		StacktraceKey:  "stacktrace",
+		EncodeLevel:    zapcore.LowercaseLevelEncoder,
+		EncodeTime:     zapcore.ISO8601TimeEncoder,
+		EncodeDuration: zapcore.StringDurationEncoder,
+		EncodeCaller:   zapcore.ShortCallerEncoder,
+	}
+}
+
+func encodeLogfmt(t *testing.T, cfg zapcore.EncoderConfig, fields ...zap.Field) string {
+	t.Helper()
+	cfg.TimeKey, cfg.LevelKey, cfg.MessageKey = "", "", ""
+	buf, err := NewLogfmtEncoder(cfg).EncodeEntry(zapcore.Entry{}, fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer buf.Free()
+	return buf.String()
+}
+
+type user struct{ id int }
+
+func (u user) MarshalLogObject(enc zapcore.ObjectEncoder) error {
+	enc.AddInt("id", u.id)
+	enc.AddString("name", "ann lee")
+	return nil
+}
+
+// pieces is an encoder that appends several pieces, like the time encoder
+// of the console outputs.
+func pieces(t time.Time, enc zapcore.PrimitiveArrayEncoder) {
+	enc.AppendString("[")
+	enc.AppendString(t.Format("15:04:05"))
+	enc.AppendString("]")
+}
+
+func TestLogfmtEncoderFields(t *testing.T) {
+	piecesCfg := testEncoderConfig()
+	piecesCfg.EncodeTime = pieces
+
+	tests := []struct {
+		name   string
+		cfg    zapcore.EncoderConfig
+		fields []zap.Field
+		want   string
+	}{
+		{"plain", testEncoderConfig(), []zap.Field{zap.String("k", "value")}, "k=value"},
+		{"empty", testEncoderConfig(), []zap.Field{zap.String("k", "")}, `k=""`},
+		{"space", testEncoderConfig(), []zap.Field{zap.String("k", "a b")}, `k="a b"`},
+		{"quote", testEncoderConfig(), []zap.Field{zap.String("k", `say "hi"`)}, `k="say \"hi\""`},
+		{"equal", testEncoderConfig(), []zap.Field{zap.String("k", "a=b")}, `k="a=b"`},
+		{"backslash", testEncoderConfig(), []zap.Field{zap.String("k", `a\b`)}, `k="a\\b"`},
+		{"control", testEncoderConfig(), []zap.Field{zap.String("k", "a\nb\tc\x01")}, `k="a\nb\tc\u0001"`},
+		{"invalid utf8", testEncoderConfig(), []zap.Field{zap.String("k", "a\xffb")}, "k=\"a�b\""},
+		{"unicode", testEncoderConfig(), []zap.Field{zap.String("k", "héllo")}, "k=héllo"},
+		{"key", testEncoderConfig(), []zap.Field{zap.String("a b=\"c", "v")}, "a_b__c=v"},
+		{"numbers", testEncoderConfig(), []zap.Field{zap.Int("i", -3), zap.Uint("u", 4), zap.Float64("f", 1.5), zap.Bool("b", true)}, "i=-3 u=4 f=1.5 b=true"},
+		{"floats", testEncoderConfig(), []zap.Field{zap.Float64("nan", nanValue()), zap.Complex128("c", complex(1, -2))}, "nan=NaN c=1-2i"},
+		{"duration", testEncoderConfig(), []zap.Field{zap.Duration("d", 1500*time.Millisecond)}, "d=1.5s"},
+		{"time", testEncoderConfig(), []zap.Field{zap.Time("t", testTime)}, "t=2021-02-03T04:05:06.789Z"},
+		{"namespace", testEncoderConfig(), []zap.Field{zap.String("a", "1"), zap.Namespace("req"), zap.String("id", "2"), zap.Namespace("db"), zap.Int("n", 3)}, "a=1 req.id=2 req.db.n=3"},
+		{"object", testEncoderConfig(), []zap.Field{zap.Object("user", user{7}), zap.String("after", "x")}, `user.id=7 user.name="ann lee" after=x`},
+		{"array", testEncoderConfig(), []zap.Field{zap.Strings("tags", []string{"a", "b"}), zap.Ints("n", []int{1, 2, 3})}, "tags=[a,b] n=[1,2,3]"},
+		{"array empty element", testEncoderConfig(), []zap.Field{zap.Strings("tags", []string{"", "b", ""})}, `tags="[\"\",b,\"\"]"`},
+		{"array empty", testEncoderConfig(), []zap.Field{zap.Strings("tags", nil)}, "tags=[]"},
+		{"array quoted", testEncoderConfig(), []zap.Field{zap.Strings("tags", []string{"a b", "c"})}, `tags="[\"a b\",c]"`},
+		{"array element comma", testEncoderConfig(), []zap.Field{zap.Strings("a", []string{"x,y", "z"})}, `a="[\"x,y\",z]"`},
+		{"array element comma last", testEncoderConfig(), []zap.Field{zap.Strings("a", []string{"x", "y,z"})}, `a="[x,\"y,z\"]"`},
+		{"array element bracket", testEncoderConfig(), []zap.Field{zap.Strings("a", []string{"]"})}, `a="[\"]\"]"`},
+		{"array element braces", testEncoderConfig(), []zap.Field{zap.Strings("a", []string{"{b}", "[c"})}, `a="[\"{b}\",\"[c\"]"`},
+		{"array element equal", testEncoderConfig(), []zap.Field{zap.Strings("a", []string{"b=c"})}, `a="[\"b=c\"]"`},
+		{"array element quote", testEncoderConfig(), []zap.Field{zap.Strings("a", []string{`say "hi"`})}, `a="[\"say \\\"hi\\\"\"]"`},
+		{"array byte strings", testEncoderConfig(), []zap.Field{zap.ByteStrings("a", [][]byte{[]byte("x,y"), []byte("z")})}, `a="[\"x,y\",z]"`},
+		{"array of arrays", testEncoderConfig(), []zap.Field{zap.Array("m", arrays{{1, 2}, {}, {3}})}, "m=[[1,2],[],[3]]"},
+		{"array of objects", testEncoderConfig(), []zap.Field{zap.Array("users", users{{1}, {2}})}, `users="[{id=1 name=\"ann lee\"},{id=2 name=\"ann lee\"}]"`},
+		{"array of times", piecesCfg, []zap.Field{zap.Times("ts", []time.Time{testTime, testTime})}, "ts=[[04:05:06],[04:05:06]]"},
+		{"array of durations", testEncoderConfig(), []zap.Field{zap.Durations("ds", []time.Duration{time.Second, time.Minute})}, "ds=[1s,1m0s]"},
+		{"error", testEncoderConfig(), []zap.Field{zap.Error(errors.New("boom"))}, "error=boom"},
+		{"named error", testEncoderConfig(), []zap.Field{zap.NamedError("dbError", errors.New("no rows"))}, `dbError="no rows"`},
+		{"wrapped error", testEncoderConfig(), []zap.Field{zap.Error(fmt.Errorf("query: %w", errors.New("no rows")))}, `error="query: no rows"`},
+		{"binary", testEncoderConfig(), []zap.Field{zap.Binary("b", []byte{0, 1, 0xfe, 0xff})}, `b="AAH+/w=="`},
+		{"byte string", testEncoderConfig(), []zap.Field{zap.ByteString("b", []byte("a b"))}, `b="a b"`},
+		{"reflected", testEncoderConfig(), []zap.Field{zap.Reflect("r", map[string]int{"a": 1})}, `r="{\"a\":1}"`},
+		{"skip", testEncoderConfig(), []zap.Field{zap.Skip(), zap.String("k", "v")}, "k=v"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := encodeLogfmt(t, tt.cfg, tt.fields...); got != tt.want+"\n" {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLogfmtEncoderEntry(t *testing.T) {
+	enc := NewLogfmtEncoder(testEncoderConfig())
+	enc.AddString("service", "payments")
+	enc.OpenNamespace("req")
+	ent := zapcore.Entry{
+		Level:      zapcore.WarnLevel,
+		Time:       testTime,
+		LoggerName: "db",
+		Message:    "slow query",
+		Caller:     zapcore.NewEntryCaller(0, "/src/app/db/query.go", 42, true),
+		Stack:      "main.main\n\t/src/main.go:10",
+	}
+	buf, err := enc.EncodeEntry(ent, []zap.Field{zap.Int("ms", 1200)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `ts=2021-02-03T04:05:06.789Z level=warn logger=db caller=db/query.go:42 msg="slow query" service=payments req.ms=1200 stacktrace="main.main\n\t/src/main.go:10"` + "\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+
+	// The encoder is not changed by the entries.
+	buf, err = enc.EncodeEntry(zapcore.Entry{Message: "again", Time: testTime}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = "ts=2021-02-03T04:05:06.789Z level=info msg=again service=payments\n"
+	if got := buf.String(); got != want {
+		t.Errorf("got  %s\nwant %s", got, want)
+	}
+}
+
+type users []user
+
+func (us users) MarshalLogArray(enc zapcore.ArrayEncoder) error {
+	for _, u := range us {
+		if err := enc.AppendObject(u); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type arrays [][]int
+
+func (a arrays) MarshalLogArray(enc zapcore.ArrayEncoder) error {
+	for _, inner := range a {
+		if err := enc.AppendArray(zapcore.ArrayMarshalerFunc(func(enc zapcore.ArrayEncoder) error {
+			for _, i := range inner {
+				enc.AppendInt(i)
+			}
+			return nil
+		})); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func nanValue() float64 {
+	zero := 0.0
+	return zero / zero
+}
@@ -0,0 +1,31 @@
+package encoder
+
+import (
+	"io"
+	"os"
+	"strings"
+)
+
+// ColorEnabled reports whether colour escape codes should be written to w.
+// It follows the usual conventions, in order:
+//
+//   - NO_COLOR set to a non-empty value disables colour,
+//   - FORCE_COLOR set to anything but "0" or "false" enables colour,
+//   - TERM=dumb disables colour,
+//   - otherwise colour is enabled if w is a terminal.
+func ColorEnabled(w io.Writer) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	if force := os.Getenv("FORCE_COLOR"); force != "" {
+		switch strings.ToLower(force) {
+		case "0", "false":
+		default:
+			return true
+		}
+	}
+	if os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	return CheckIfTerminal(w)
+}
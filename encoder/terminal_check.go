@@ -1,4 +1,5 @@
-// +build !windows
+//go:build !windows && !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd
+// +build !windows,!linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd
 
 package encoder
 
@@ -0,0 +1,5 @@
+package encoder
+
+import "golang.org/x/sys/unix"
+
+const ioctlReadTermios = unix.TCGETS
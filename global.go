@@ -0,0 +1,68 @@
+package log
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+)
+
+// globalLogger is the package-level logger. It is replaced as a whole so that
+// readers never see a logger without the matching state.
+type globalLogger struct {
+	// l is the logger as it was built or passed to ReplaceGlobal.
+	l *zap.Logger
+	// skipped skips one more caller frame, for the package-level functions
+	// that wrap it.
+	skipped *zap.Logger
+	// ctxSkipped skips two more caller frames, for the *Ctx functions that
+	// check the entry through a helper.
+	ctxSkipped *zap.Logger
+	// sugared is skipped, sugared for the sugared functions.
+	sugared *zap.SugaredLogger
+	// state is nil if the logger was not built by InitLog.
+	state *logState
+}
+
+var (
+	_globalMu sync.Mutex // serializes replacements
+	_global   atomic.Value
+)
+
+func global() *globalLogger {
+	return _global.Load().(*globalLogger)
+}
+
+func newGlobalLogger(l *zap.Logger, state *logState) *globalLogger {
+	skipped := l.WithOptions(zap.AddCallerSkip(1))
+	return &globalLogger{
+		l:          l,
+		skipped:    skipped,
+		ctxSkipped: l.WithOptions(zap.AddCallerSkip(2)),
+		sugared:    skipped.Sugar(),
+		state:      state,
+	}
+}
+
+func replaceGlobal(g *globalLogger) func() {
+	_globalMu.Lock()
+	defer _globalMu.Unlock()
+	prev, _ := _global.Load().(*globalLogger)
+	_global.Store(g)
+	return func() {
+		if prev != nil {
+			replaceGlobal(prev)
+		}
+	}
+}
+
+// ReplaceGlobal replaces the global logger used by the package-level
+// functions and returns a function to restore the previous one. It is safe
+// for concurrent use. The logger is used as is by L and With; the
+// package-level functions such as Info and InfoCtx skip their own frames when
+// reporting the caller.
+//
+// A logger replaced this way is not affected by ReloadLog.
+func ReplaceGlobal(logger *zap.Logger) func() {
+	return replaceGlobal(newGlobalLogger(logger, nil))
+}
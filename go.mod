@@ -3,9 +3,15 @@ module github.com/liasece/log
 go 1.16
 
 require (
+	github.com/fsnotify/fsnotify v1.4.7
 	github.com/getsentry/sentry-go v0.10.0
+	github.com/golang/snappy v1.0.0
 	github.com/konsorten/go-windows-terminal-sequences v1.0.3
+	github.com/mitchellh/mapstructure v1.1.2
 	github.com/spf13/viper v1.7.1
 	go.elastic.co/apm v1.11.0
+	go.opentelemetry.io/otel/trace v1.0.0
+	go.uber.org/multierr v1.5.0
 	go.uber.org/zap v1.16.0
+	golang.org/x/sys v0.0.0-20191204072324-ce4227a45e2e
 )
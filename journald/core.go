@@ -0,0 +1,224 @@
+package logjournald
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	logasync "github.com/liasece/log/async"
+	"go.uber.org/zap/zapcore"
+)
+
+// NewCore creates a zap core that sends the entries to journald through w.
+// The fields of the entries become journal fields, their keys upper-cased
+// with the characters other than letters and digits replaced by
+// underscores: "user.id" is USER_ID. The fields named like the journal fields
+// written by the core get the prefix FIELD_: "message" is FIELD_MESSAGE. The
+// entries are sent on the calling goroutine, see NewAsyncCore.
+func NewCore(w *Writer, enab zapcore.LevelEnabler) zapcore.Core {
+	return &core{LevelEnabler: enab, w: w}
+}
+
+// NewAsyncCore creates a core like NewCore that sends the entries through
+// aw, a logasync.Writer writing to w, so that a busy journald doesn't block
+// the callers. The entries above ErrorLevel are sent before Write returns,
+// as the program may be about to exit.
+func NewAsyncCore(w *Writer, aw *logasync.Writer, enab zapcore.LevelEnabler) zapcore.Core {
+	return &core{LevelEnabler: enab, w: w, async: aw}
+}
+
+type core struct {
+	zapcore.LevelEnabler
+	w     *Writer
+	async *logasync.Writer
+	// fields are the fields added by With, formatted with every entry.
+	fields []zapcore.Field
+}
+
+func (c *core) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.fields = append(c.fields[:len(c.fields):len(c.fields)], fields...)
+	return &clone
+}
+
+func (c *core) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *core) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	b := make([]byte, 0, 512)
+	b = appendField(b, "MESSAGE", ent.Message)
+	b = appendField(b, "PRIORITY", strconv.Itoa(journalPriority(ent.Level)))
+	b = appendField(b, "SYSLOG_IDENTIFIER", c.w.cfg.Identifier)
+	if ent.LoggerName != "" {
+		b = appendField(b, "LOGGER", ent.LoggerName)
+	}
+	if ent.Caller.Defined {
+		b = appendField(b, "CODE_FILE", ent.Caller.File)
+		b = appendField(b, "CODE_LINE", strconv.Itoa(ent.Caller.Line))
+		if ent.Caller.Function != "" {
+			b = appendField(b, "CODE_FUNC", ent.Caller.Function)
+		}
+	}
+	if ent.Stack != "" {
+		b = appendField(b, "STACKTRACE", ent.Stack)
+	}
+	b = appendFields(b, "", c.fields)
+	b = appendFields(b, "", fields)
+	if c.async == nil {
+		return c.w.send(b)
+	}
+	_, _ = c.async.WriteLevel(ent.Level, b)
+	if ent.Level > zapcore.ErrorLevel {
+		return c.async.Sync()
+	}
+	return nil
+}
+
+// Sync waits for the entries buffered by an asynchronous core to be sent.
+func (c *core) Sync() error {
+	if c.async == nil {
+		return nil
+	}
+	return c.async.Sync()
+}
+
+// Priorities, the syslog severities, see RFC 5424.
+const (
+	priorityEmergency = iota
+	priorityAlert
+	priorityCritical
+	priorityError
+	priorityWarning
+	priorityNotice
+	priorityInfo
+	priorityDebug
+)
+
+func journalPriority(lvl zapcore.Level) int {
+	switch lvl {
+	case zapcore.DebugLevel:
+		return priorityDebug
+	case zapcore.InfoLevel:
+		return priorityInfo
+	case zapcore.WarnLevel:
+		return priorityWarning
+	case zapcore.ErrorLevel:
+		return priorityError
+	case zapcore.DPanicLevel:
+		return priorityCritical
+	case zapcore.PanicLevel:
+		return priorityCritical
+	case zapcore.FatalLevel:
+		return priorityCritical
+	default:
+		// Unrecognized levels are critical.
+		return priorityCritical
+	}
+}
+
+// appendFields appends the fields in order, the keys of objects and
+// namespaces joined by underscores.
+func appendFields(b []byte, prefix string, fields []zapcore.Field) []byte {
+	for _, f := range fields {
+		switch f.Type {
+		case zapcore.SkipType:
+			continue
+		case zapcore.NamespaceType:
+			prefix += f.Key + "_"
+			continue
+		}
+		enc := zapcore.NewMapObjectEncoder()
+		f.AddTo(enc)
+		b = appendMap(b, prefix, enc.Fields)
+	}
+	return b
+}
+
+func appendMap(b []byte, prefix string, m map[string]interface{}) []byte {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if nested, ok := m[k].(map[string]interface{}); ok {
+			b = appendMap(b, prefix+k+"_", nested)
+			continue
+		}
+		name := fieldName(prefix + k)
+		if reservedFields[name] {
+			name = reservedPrefix + name
+		}
+		b = appendField(b, name, fieldValue(m[k]))
+	}
+	return b
+}
+
+// reservedFields are the journal fields written by the core, which the
+// fields of the entries can't override.
+var reservedFields = map[string]bool{
+	"MESSAGE":           true,
+	"PRIORITY":          true,
+	"SYSLOG_IDENTIFIER": true,
+	"LOGGER":            true,
+	"CODE_FILE":         true,
+	"CODE_LINE":         true,
+	"CODE_FUNC":         true,
+	"STACKTRACE":        true,
+}
+
+// reservedPrefix is prepended to the names of the fields of the entries
+// that are reserved.
+const reservedPrefix = "FIELD_"
+
+// fieldName returns key as a valid journal field name: upper-case letters,
+// digits and underscores, not starting with an underscore or a digit, which
+// are reserved for the fields added by journald.
+func fieldName(key string) string {
+	var sb strings.Builder
+	for i := 0; i < len(key) && sb.Len() < 64; i++ {
+		c := key[i]
+		switch {
+		case c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c >= 'a' && c <= 'z':
+			c -= 'a' - 'A'
+		default:
+			c = '_'
+		}
+		if sb.Len() == 0 && (c == '_' || c >= '0' && c <= '9') {
+			continue
+		}
+		sb.WriteByte(c)
+	}
+	if sb.Len() == 0 {
+		return "FIELD"
+	}
+	return sb.String()
+}
+
+// fieldValue returns the basic values as fmt prints them, the others as
+// JSON.
+func fieldValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case time.Duration:
+		return v.String()
+	case bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64,
+		uintptr, float32, float64, complex64, complex128:
+		return fmt.Sprint(v)
+	}
+	if b, err := json.Marshal(v); err == nil {
+		return string(b)
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,59 @@
+package logjournald
+
+import (
+	"testing"
+
+	"go.uber.org/zap/zapcore"
+)
+
+func TestFieldName(t *testing.T) {
+	for key, want := range map[string]string{
+		"user.id":     "USER_ID",
+		"requestID":   "REQUESTID",
+		"_private":    "PRIVATE",
+		"1st":         "ST",
+		"__":          "FIELD",
+		"http-code":   "HTTP_CODE",
+		"caf\xc3\xa9": "CAF__",
+	} {
+		if got := fieldName(key); got != want {
+			t.Errorf("fieldName(%q) = %q, want %q", key, got, want)
+		}
+	}
+}
+
+func TestAppendFieldsReserved(t *testing.T) {
+	fields := []zapcore.Field{
+		{Key: "message", Type: zapcore.StringType, String: "mine"},
+		{Key: "priority", Type: zapcore.Int64Type, Integer: 1},
+		{Key: "code_file", Type: zapcore.StringType, String: "x.go"},
+		{Key: "syslog_identifier", Type: zapcore.StringType, String: "other"},
+		{Key: "messages", Type: zapcore.StringType, String: "kept"},
+	}
+	got := string(appendFields(nil, "", fields))
+	want := "FIELD_MESSAGE=mine\n" +
+		"FIELD_PRIORITY=1\n" +
+		"FIELD_CODE_FILE=x.go\n" +
+		"FIELD_SYSLOG_IDENTIFIER=other\n" +
+		"MESSAGES=kept\n"
+	if got != want {
+		t.Errorf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestJournalPriority(t *testing.T) {
+	for lvl, want := range map[zapcore.Level]int{
+		zapcore.DebugLevel:  7,
+		zapcore.InfoLevel:   6,
+		zapcore.WarnLevel:   4,
+		zapcore.ErrorLevel:  3,
+		zapcore.DPanicLevel: 2,
+		zapcore.PanicLevel:  2,
+		zapcore.FatalLevel:  2,
+		zapcore.Level(42):   2,
+	} {
+		if got := journalPriority(lvl); got != want {
+			t.Errorf("journalPriority(%v) = %d, want %d", lvl, got, want)
+		}
+	}
+}
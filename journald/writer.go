@@ -0,0 +1,134 @@
+package logjournald
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// DefaultPath is the socket of the native protocol of journald.
+const DefaultPath = "/run/systemd/journal/socket"
+
+// Configuration is the set of parameters of a journald connection.
+type Configuration struct {
+	// Path is the socket of journald, DefaultPath if empty.
+	Path string
+	// Identifier is the SYSLOG_IDENTIFIER of the entries, the name of the
+	// executable if empty.
+	Identifier string
+}
+
+// Writer sends entries to journald with its native protocol, see
+// https://systemd.io/JOURNAL_NATIVE_PROTOCOL/. It reconnects if journald
+// restarts.
+type Writer struct {
+	cfg  Configuration
+	addr *net.UnixAddr
+
+	mu   sync.Mutex
+	conn *net.UnixConn
+}
+
+// NewWriter opens a socket to send entries to journald. It fails if journald
+// is not listening.
+func NewWriter(cfg Configuration) (*Writer, error) {
+	if cfg.Path == "" {
+		cfg.Path = DefaultPath
+	}
+	if cfg.Identifier == "" {
+		cfg.Identifier = filepath.Base(os.Args[0])
+	}
+	w := &Writer{
+		cfg:  cfg,
+		addr: &net.UnixAddr{Name: cfg.Path, Net: "unixgram"},
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.connect(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// connect must be called with mu held.
+func (w *Writer) connect() error {
+	conn, err := net.DialUnix("unixgram", nil, w.addr)
+	if err != nil {
+		return fmt.Errorf("logjournald: %w", err)
+	}
+	w.conn = conn
+	return nil
+}
+
+// send sends the fields of one entry. The entries too large for a datagram
+// are passed in a sealed memory file where supported. A failed entry is
+// sent again on a new connection.
+func (w *Writer) send(data []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if w.conn == nil {
+			if err = w.connect(); err != nil {
+				continue
+			}
+		}
+		if _, err = w.conn.Write(data); err == nil {
+			return nil
+		}
+		if lerr := w.sendLarge(data, err); lerr != err {
+			return lerr
+		}
+		_ = w.conn.Close()
+		w.conn = nil
+	}
+	return err
+}
+
+// Write sends p as the fields of one entry, so that w can be the
+// destination of a logasync.Writer.
+func (w *Writer) Write(p []byte) (int, error) {
+	if err := w.send(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Sync does nothing, the entries are not buffered.
+func (w *Writer) Sync() error {
+	return nil
+}
+
+// Close closes the socket. Sending reconnects.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.conn == nil {
+		return nil
+	}
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
+
+// appendField appends a field in the format of the native protocol: KEY=value
+// on one line, or the length of the value in binary if it has new lines.
+func appendField(b []byte, key, value string) []byte {
+	b = append(b, key...)
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\n' {
+			b = append(b, '\n')
+			var size [8]byte
+			binary.LittleEndian.PutUint64(size[:], uint64(len(value)))
+			b = append(b, size[:]...)
+			b = append(b, value...)
+			return append(b, '\n')
+		}
+	}
+	b = append(b, '=')
+	b = append(b, value...)
+	return append(b, '\n')
+}
@@ -0,0 +1,55 @@
+//go:build linux
+// +build linux
+
+package logjournald
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+var errTooLarge = errors.New("logjournald: entry too large and memfd is not supported")
+
+// sendLarge sends data in a sealed memory file if err says it is too large
+// for a datagram, as journald accepts. It returns err if it doesn't apply.
+func (w *Writer) sendLarge(data []byte, err error) error {
+	if !errors.Is(err, syscall.EMSGSIZE) && !errors.Is(err, syscall.ENOBUFS) {
+		return err
+	}
+	fd, err := unix.MemfdCreate("logjournald", unix.MFD_CLOEXEC|unix.MFD_ALLOW_SEALING)
+	if err != nil {
+		if errors.Is(err, syscall.ENOSYS) {
+			return errTooLarge
+		}
+		return fmt.Errorf("logjournald: %w", err)
+	}
+	f := os.NewFile(uintptr(fd), "logjournald")
+	defer f.Close()
+	if _, err := f.Write(data); err != nil {
+		return fmt.Errorf("logjournald: %w", err)
+	}
+	seals := unix.F_SEAL_SHRINK | unix.F_SEAL_GROW | unix.F_SEAL_WRITE | unix.F_SEAL_SEAL
+	if _, err := unix.FcntlInt(f.Fd(), unix.F_ADD_SEALS, seals); err != nil {
+		return fmt.Errorf("logjournald: %w", err)
+	}
+	// The connection is connected, WriteMsgUnix refuses it.
+	raw, err := w.conn.SyscallConn()
+	if err != nil {
+		return fmt.Errorf("logjournald: %w", err)
+	}
+	var serr error
+	if err := raw.Write(func(sock uintptr) bool {
+		serr = unix.Sendmsg(int(sock), nil, unix.UnixRights(int(f.Fd())), nil, 0)
+		return serr != unix.EAGAIN
+	}); err != nil {
+		return fmt.Errorf("logjournald: %w", err)
+	}
+	if serr != nil {
+		return fmt.Errorf("logjournald: %w", serr)
+	}
+	return nil
+}
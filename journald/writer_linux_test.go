@@ -0,0 +1,228 @@
+//go:build linux
+// +build linux
+
+package logjournald
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	logasync "github.com/liasece/log/async"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"golang.org/x/sys/unix"
+)
+
+// listen starts a stand-in journald on a socket of a temporary directory.
+func listen(t *testing.T) (*net.UnixConn, string) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "logjournald")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	path := filepath.Join(dir, "socket")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = conn.Close() })
+	return conn, path
+}
+
+// receive reads one entry, from a datagram or from the memory file passed
+// with it, and reports whether it came in a memory file.
+func receive(t *testing.T, conn *net.UnixConn) (fields map[string]string, memfd bool) {
+	t.Helper()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1<<16)
+	oob := make([]byte, unix.CmsgSpace(4))
+	n, oobn, _, _, err := conn.ReadMsgUnix(buf, oob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := buf[:n]
+	if oobn > 0 {
+		msgs, err := unix.ParseSocketControlMessage(oob[:oobn])
+		if err != nil {
+			t.Fatal(err)
+		}
+		fds, err := unix.ParseUnixRights(&msgs[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		f := os.NewFile(uintptr(fds[0]), "memfd")
+		defer f.Close()
+		if _, err := f.Seek(0, 0); err != nil {
+			t.Fatal(err)
+		}
+		if data, err = ioutil.ReadAll(f); err != nil {
+			t.Fatal(err)
+		}
+		memfd = true
+	}
+	return parseEntry(t, data), memfd
+}
+
+// parseEntry parses the native protocol, failing on repeated fields.
+func parseEntry(t *testing.T, data []byte) map[string]string {
+	t.Helper()
+	fields := make(map[string]string)
+	for len(data) > 0 {
+		i := bytes.IndexAny(data, "=\n")
+		if i < 0 {
+			t.Fatalf("truncated entry %q", data)
+		}
+		key := string(data[:i])
+		var value string
+		if data[i] == '=' {
+			end := bytes.IndexByte(data, '\n')
+			value, data = string(data[i+1:end]), data[end+1:]
+		} else {
+			size := int(binary.LittleEndian.Uint64(data[i+1 : i+9]))
+			value, data = string(data[i+9:i+9+size]), data[i+10+size:]
+		}
+		if _, ok := fields[key]; ok {
+			t.Errorf("field %s repeated", key)
+		}
+		fields[key] = value
+	}
+	return fields
+}
+
+func TestWriterNativeProtocol(t *testing.T) {
+	conn, path := listen(t)
+	w, err := NewWriter(Configuration{Path: path, Identifier: "app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	core := NewCore(w, zapcore.DebugLevel).With([]zap.Field{zap.String("user.id", "ann")})
+	ent := zapcore.Entry{
+		Level:      zapcore.WarnLevel,
+		Time:       time.Now(),
+		LoggerName: "payments",
+		Message:    "two\nlines",
+		Caller:     zapcore.NewEntryCaller(0, "/src/app/main.go", 12, true),
+		Stack:      "main.main\n\t/src/app/main.go:12",
+	}
+	fields := []zap.Field{
+		zap.String("message", "mine"),
+		zap.Int("priority", 0),
+		zap.Namespace("http"),
+		zap.Int("status", 500),
+	}
+	if err := core.Write(ent, fields); err != nil {
+		t.Fatal(err)
+	}
+
+	got, memfd := receive(t, conn)
+	if memfd {
+		t.Error("small entry sent in a memory file")
+	}
+	want := map[string]string{
+		"MESSAGE":           "two\nlines",
+		"PRIORITY":          "4",
+		"SYSLOG_IDENTIFIER": "app",
+		"LOGGER":            "payments",
+		"CODE_FILE":         "/src/app/main.go",
+		"CODE_LINE":         "12",
+		"STACKTRACE":        "main.main\n\t/src/app/main.go:12",
+		"USER_ID":           "ann",
+		"FIELD_MESSAGE":     "mine",
+		"FIELD_PRIORITY":    "0",
+		"HTTP_STATUS":       "500",
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %q, want %q", k, got[k], v)
+		}
+	}
+	if len(got) != len(want) {
+		t.Errorf("got %d fields, want %d: %v", len(got), len(want), got)
+	}
+}
+
+func TestWriterMemfd(t *testing.T) {
+	conn, path := listen(t)
+	w, err := NewWriter(Configuration{Path: path, Identifier: "app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// Larger than the send buffer of the socket, which bounds datagrams.
+	large := strings.Repeat("x", 4<<20)
+	ent := zapcore.Entry{Level: zapcore.InfoLevel, Time: time.Now(), Message: "large"}
+	if err := NewCore(w, zapcore.DebugLevel).Write(ent, []zap.Field{zap.String("payload", large)}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, memfd := receive(t, conn)
+	if !memfd {
+		t.Error("large entry not sent in a memory file")
+	}
+	if got["MESSAGE"] != "large" || got["PAYLOAD"] != large {
+		t.Errorf("got MESSAGE %q and a PAYLOAD of %d bytes", got["MESSAGE"], len(got["PAYLOAD"]))
+	}
+}
+
+func TestWriterReconnects(t *testing.T) {
+	conn, path := listen(t)
+	w, err := NewWriter(Configuration{Path: path, Identifier: "app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	// journald restarts: the socket is replaced.
+	_ = conn.Close()
+	_ = os.Remove(path)
+	conn, err = net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ent := zapcore.Entry{Level: zapcore.InfoLevel, Time: time.Now(), Message: "again"}
+	if err := NewCore(w, zapcore.DebugLevel).Write(ent, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := receive(t, conn); got["MESSAGE"] != "again" {
+		t.Errorf("got %v", got)
+	}
+}
+
+func TestAsyncCore(t *testing.T) {
+	conn, path := listen(t)
+	w, err := NewWriter(Configuration{Path: path, Identifier: "app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	aw, err := logasync.NewWriter(w, logasync.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer aw.Close()
+
+	core := NewAsyncCore(w, aw, zapcore.DebugLevel)
+	ent := zapcore.Entry{Level: zapcore.InfoLevel, Time: time.Now(), Message: "async"}
+	if err := core.Write(ent, []zap.Field{zap.Int("n", 1)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := core.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := receive(t, conn); got["MESSAGE"] != "async" || got["N"] != "1" {
+		t.Errorf("got %v", got)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package logjournald
+
+// sendLarge returns err, memory files are only passed on Linux. The entries
+// too large for a datagram are lost.
+func (w *Writer) sendLarge(data []byte, err error) error {
+	return err
+}
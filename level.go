@@ -0,0 +1,236 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// levelInherit removes the level of a named logger, which then uses the
+// level of its parent.
+const levelInherit = "inherit"
+
+// levelOverrides tracks the levels set for a limited time, and the levels to
+// restore once they expire. Root is the empty name.
+type levelOverrides struct {
+	mu      sync.Mutex
+	pending map[string]*levelOverride
+}
+
+type levelOverride struct {
+	timer   *time.Timer
+	restore *zapcore.Level // nil if the module had no level
+	expires time.Time
+}
+
+// set changes the level of name, restoring the level it had before the first
+// pending override after ttl. A zero ttl makes the change permanent. A nil
+// level removes the level of a module.
+func (o *levelOverrides) set(levels *moduleLevels, name string, lvl *zapcore.Level, ttl time.Duration) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	var restore *zapcore.Level
+	if cur, ok := levels.get(name); ok {
+		restore = &cur
+	}
+	if p, ok := o.pending[name]; ok {
+		p.timer.Stop()
+		delete(o.pending, name)
+		restore = p.restore
+	}
+	levels.set(name, lvl)
+	if ttl <= 0 {
+		return
+	}
+
+	p := &levelOverride{restore: restore, expires: time.Now().Add(ttl)}
+	p.timer = time.AfterFunc(ttl, func() {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		if o.pending[name] != p {
+			// Replaced or cancelled in the meantime.
+			return
+		}
+		levels.set(name, p.restore)
+		delete(o.pending, name)
+	})
+	if o.pending == nil {
+		o.pending = make(map[string]*levelOverride)
+	}
+	o.pending[name] = p
+}
+
+// cancel forgets the pending overrides, keeping the current levels.
+func (o *levelOverrides) cancel() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for name, p := range o.pending {
+		p.timer.Stop()
+		delete(o.pending, name)
+	}
+}
+
+// snapshot returns the levels and their pending overrides, including the
+// modules that only have a level because of an override.
+func (o *levelOverrides) snapshot(levels *moduleLevels) levelsResponse {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	resp := levelsResponse{levelState: o.state(levels, "")}
+	names := levels.names()
+	for name := range o.pending {
+		if _, ok := levels.get(name); !ok && name != "" {
+			names = append(names, name)
+		}
+	}
+	if len(names) > 0 {
+		resp.Levels = make(map[string]levelState, len(names))
+		for _, name := range names {
+			resp.Levels[name] = o.state(levels, name)
+		}
+	}
+	return resp
+}
+
+func (o *levelOverrides) state(levels *moduleLevels, name string) levelState {
+	var st levelState
+	if lvl, ok := levels.get(name); ok {
+		st.Level = lvl.String()
+	} else {
+		st.Level = levelInherit
+	}
+	if p, ok := o.pending[name]; ok {
+		expires := p.expires
+		st.Expires = &expires
+		st.Restore = levelInherit
+		if p.restore != nil {
+			st.Restore = p.restore.String()
+		}
+	}
+	return st
+}
+
+type levelState struct {
+	Level   string     `json:"level"`
+	Expires *time.Time `json:"expires,omitempty"`
+	Restore string     `json:"restore,omitempty"`
+}
+
+type levelsResponse struct {
+	levelState
+	Levels map[string]levelState `json:"levels,omitempty"`
+}
+
+type levelRequest struct {
+	Name  string `json:"name"`
+	Level string `json:"level"`
+	TTL   string `json:"ttl"`
+}
+
+type errorPayload struct {
+	Error string `json:"error"`
+}
+
+type levelHandler struct{}
+
+// LevelHandler returns an http.Handler that reports the levels of the global
+// logger and of the loggers created by Named as JSON on GET, and changes one
+// of them on PUT:
+//
+//	curl -X PUT -d '{"level":"debug","ttl":"5m"}' localhost:8080/log/level
+//	curl -X PUT -d '{"name":"payments","level":"debug"}' localhost:8080/log/level
+//
+// The body can also be a form with the same keys, e.g. level=debug&ttl=5m.
+// With a ttl, the previous level is restored once it expires; a later PUT
+// replaces a pending restore. ReloadLog cancels any pending restore. The
+// level "inherit" removes the level of a named logger.
+func LevelHandler() http.Handler {
+	return levelHandler{}
+}
+
+func (levelHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s := global().state
+	if s == nil {
+		writeJSON(w, http.StatusServiceUnavailable, errorPayload{Error: "logger was not initialized by InitLog"})
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		req, err := readLevelRequest(r)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorPayload{Error: err.Error()})
+			return
+		}
+		var lvl *zapcore.Level
+		switch {
+		case req.Level == "":
+			err = errors.New("level: must be specified")
+		case req.Level == levelInherit:
+			if req.Name == "" {
+				err = errors.New("level: only named loggers can inherit their level")
+			}
+		default:
+			var l zapcore.Level
+			l, err = parseLevel("level", req.Level, zapcore.DebugLevel)
+			lvl = &l
+		}
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, errorPayload{Error: err.Error()})
+			return
+		}
+		var ttl time.Duration
+		if req.TTL != "" {
+			if ttl, err = time.ParseDuration(req.TTL); err != nil || ttl < 0 {
+				writeJSON(w, http.StatusBadRequest, errorPayload{Error: fmt.Sprintf("ttl: invalid duration %q", req.TTL)})
+				return
+			}
+		}
+		s.overrides.set(s.levels, req.Name, lvl, ttl)
+	default:
+		writeJSON(w, http.StatusMethodNotAllowed, errorPayload{Error: "only GET and PUT are supported"})
+		return
+	}
+
+	resp := s.overrides.snapshot(s.levels)
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// readLevelRequest reads a JSON object, or form values for anything that
+// doesn't look like one, whatever the Content-Type says.
+func readLevelRequest(r *http.Request) (levelRequest, error) {
+	var req levelRequest
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1<<16))
+	if err != nil {
+		return req, err
+	}
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '{' {
+		if err := json.Unmarshal(trimmed, &req); err != nil {
+			return req, fmt.Errorf("invalid JSON body: %w", err)
+		}
+		return req, nil
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		return req, fmt.Errorf("invalid form body: %w", err)
+	}
+	req.Name = form.Get("name")
+	req.Level = form.Get("level")
+	req.TTL = form.Get("ttl")
+	return req, nil
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
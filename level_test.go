@@ -0,0 +1,118 @@
+package log
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// serveLevel sends a request to the LevelHandler.
+func serveLevel(t *testing.T, method, body string) (int, levelsResponse) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	LevelHandler().ServeHTTP(rec, httptest.NewRequest(method, "/log/level", strings.NewReader(body)))
+	var resp levelsResponse
+	if rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rec.Code, resp
+}
+
+func TestLevelHandler(t *testing.T) {
+	initTestLog(t, &Config{
+		Level:   "info",
+		Levels:  map[string]string{"payments": "warn"},
+		Outputs: []OutputConfig{{Type: outputStderr}},
+	})
+
+	code, resp := serveLevel(t, http.MethodGet, "")
+	if code != http.StatusOK || resp.Level != "info" || resp.Levels["payments"].Level != "warn" {
+		t.Fatalf("GET: %d %+v", code, resp)
+	}
+
+	code, resp = serveLevel(t, http.MethodPut, `{"level":"debug"}`)
+	if code != http.StatusOK || resp.Level != "debug" || resp.Expires != nil {
+		t.Errorf("PUT root: %d %+v", code, resp)
+	}
+	if ce := Named("other").Check(zapcore.DebugLevel, "msg"); ce == nil {
+		t.Error("debug entries of other modules are disabled")
+	}
+
+	code, resp = serveLevel(t, http.MethodPut, "name=payments.stripe&level=error")
+	if code != http.StatusOK || resp.Levels["payments.stripe"].Level != "error" {
+		t.Errorf("PUT form: %d %+v", code, resp)
+	}
+	code, resp = serveLevel(t, http.MethodPut, `{"name":"payments","level":"inherit"}`)
+	if _, ok := resp.Levels["payments"]; code != http.StatusOK || ok {
+		t.Errorf("PUT inherit: %d %+v", code, resp)
+	}
+
+	for _, body := range []string{
+		`{"level":"loud"}`,
+		`{"level":""}`,
+		`{"level":"inherit"}`,
+		`{"level":"debug","ttl":"soon"}`,
+		`{"level":"debug","ttl":"-1s"}`,
+		`{"level":`,
+	} {
+		if code, _ := serveLevel(t, http.MethodPut, body); code != http.StatusBadRequest {
+			t.Errorf("PUT %s: %d, want %d", body, code, http.StatusBadRequest)
+		}
+	}
+	if code, _ := serveLevel(t, http.MethodPost, `{"level":"debug"}`); code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: %d, want %d", code, http.StatusMethodNotAllowed)
+	}
+}
+
+func TestLevelHandlerRestoresAfterTTL(t *testing.T) {
+	s := initTestLog(t, &Config{Level: "warn", Outputs: []OutputConfig{{Type: outputStderr}}})
+
+	code, resp := serveLevel(t, http.MethodPut, `{"level":"debug","ttl":"100ms"}`)
+	if code != http.StatusOK || resp.Level != "debug" || resp.Expires == nil || resp.Restore != "warn" {
+		t.Fatalf("PUT: %d %+v", code, resp)
+	}
+	// A later override keeps the level to restore.
+	code, resp = serveLevel(t, http.MethodPut, `{"level":"info","ttl":"100ms"}`)
+	if code != http.StatusOK || resp.Restore != "warn" {
+		t.Fatalf("second PUT: %d %+v", code, resp)
+	}
+	// A module that only has a level for a while inherits it again.
+	code, resp = serveLevel(t, http.MethodPut, `{"name":"payments","level":"debug","ttl":"100ms"}`)
+	if code != http.StatusOK || resp.Levels["payments"].Restore != "inherit" {
+		t.Fatalf("module PUT: %d %+v", code, resp)
+	}
+
+	waitFor(t, "the levels to be restored", func() bool {
+		lvl, _ := s.levels.get("")
+		_, named := s.levels.get("payments")
+		return lvl == zapcore.WarnLevel && !named
+	})
+	if _, resp := serveLevel(t, http.MethodGet, ""); resp.Expires != nil || len(resp.Levels) != 0 {
+		t.Errorf("GET after the TTL: %+v", resp)
+	}
+}
+
+func TestLevelHandlerWithoutInitLog(t *testing.T) {
+	observe(t, zapcore.DebugLevel)
+	if code, _ := serveLevel(t, http.MethodGet, ""); code != http.StatusServiceUnavailable {
+		t.Errorf("GET: %d, want %d", code, http.StatusServiceUnavailable)
+	}
+}
+
+func TestLevelHandlerPermanentLevelOutlivesTTL(t *testing.T) {
+	s := initTestLog(t, &Config{Level: "warn", Outputs: []OutputConfig{{Type: outputStderr}}})
+	serveLevel(t, http.MethodPut, `{"level":"debug","ttl":"50ms"}`)
+	// A PUT without ttl replaces the pending restore.
+	serveLevel(t, http.MethodPut, `{"level":"error"}`)
+	time.Sleep(100 * time.Millisecond)
+	if lvl, _ := s.levels.get(""); lvl != zapcore.ErrorLevel {
+		t.Errorf("level is %v, want error", lvl)
+	}
+}
@@ -10,25 +10,46 @@ import (
 	"github.com/getsentry/sentry-go"
 	logsentry "github.com/liasece/log/sentry"
 	"github.com/spf13/viper"
+	"go.uber.org/zap/zapcore"
 )
 
-// InitLog Init logging
+// InitLog Init logging, configured by the "logging" key of cfg, see Config.
+// If fileName is not empty and no outputs are configured, entries are also
+// written to that file.
 func InitLog(fileName string, cfg *viper.Viper) error {
-	return initZapLogger(cfg.GetString("logging.level"))
+	c, err := LoadConfig(cfg)
+	if err != nil {
+		return err
+	}
+	return initZapLogger(c, fileName)
+}
+
+// InitLogByConfig Init logging with a configuration built in code
+func InitLogByConfig(cfg Config) error {
+	return initZapLogger(&cfg, "")
 }
 
 // InitLogByLevel Init logging
 func InitLogByLevel(level string) error {
-	return initZapLogger(level)
+	return initZapLogger(&Config{Level: level}, "")
 }
 
 // InitSentry initialize sentry client and log sentry hook
 func InitSentry(options sentry.ClientOptions) {
-	sentrycore, err := getSentryCore(options)
-	if err != nil || _globalL == nil {
+	sentrycore, err := getSentryCore(options, logsentry.Configuration{
+		Level: zapcore.ErrorLevel, //when to send message to sentry
+		Tags: map[string]string{
+			"component": "system",
+		},
+		FlushTimeout: time.Second * 5,
+	})
+	if err != nil {
 		Panic("initialize sentry failed", NamedError("init sentry", err))
 	} else {
-		_globalL = logsentry.AttachCoreToLogger(sentrycore, _globalL)
+		_globalMu.Lock()
+		g := global()
+		_global.Store(newGlobalLogger(logsentry.AttachCoreToLogger(sentrycore, g.l), g.state))
+		_globalMu.Unlock()
 		Info("initialize sentry ok")
 	}
 }
@@ -0,0 +1,257 @@
+package log
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+
+	"go.elastic.co/apm"
+	"go.elastic.co/apm/apmtest"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// observe replaces the global logger with one that records the entries at
+// and above lvl, until the end of the test.
+func observe(t *testing.T, lvl zapcore.Level) *observer.ObservedLogs {
+	t.Helper()
+	core, logs := observer.New(lvl)
+	t.Cleanup(ReplaceGlobal(zap.New(core, zap.AddCaller())))
+	return logs
+}
+
+// callerLine returns the line it is called from.
+func callerLine() int {
+	_, _, line, _ := runtime.Caller(1)
+	return line
+}
+
+// takeOne returns the only entry logged since the last call.
+func takeOne(t *testing.T, logs *observer.ObservedLogs) observer.LoggedEntry {
+	t.Helper()
+	entries := logs.TakeAll()
+	if len(entries) != 1 {
+		t.Fatalf("logged %d entries, want 1", len(entries))
+	}
+	return entries[0]
+}
+
+func TestCaller(t *testing.T) {
+	logs := observe(t, zapcore.DebugLevel)
+	ctx := ContextWithFields(context.Background(), zap.String("request_id", "r1"))
+	ctxLogger := ContextWithLogger(context.Background(), Named("module"))
+
+	tests := []struct {
+		name string
+		log  func()
+		line int
+	}{
+		{"Info", func() { Info("msg") }, callerLine()},
+		{"Error", func() { Error("msg") }, callerLine()},
+		{"InfoCtx", func() { InfoCtx(ctx, "msg") }, callerLine()},
+		{"InfoCtx nil", func() { InfoCtx(nil, "msg") }, callerLine()},
+		{"InfoCtx logger", func() { InfoCtx(ctxLogger, "msg") }, callerLine()},
+		{"Infof", func() { Infof("msg %d", 1) }, callerLine()},
+		{"Infow", func() { Infow("msg", "k", 1) }, callerLine()},
+		{"InfoCtxf", func() { InfoCtxf(ctx, "msg %d", 1) }, callerLine()},
+		{"InfoCtxw", func() { InfoCtxw(ctx, "msg", "k", 1) }, callerLine()},
+		{"InfoCtxw logger", func() { InfoCtxw(ctxLogger, "msg", "k", 1) }, callerLine()},
+		{"L", func() { L(ctx).Info("msg") }, callerLine()},
+		{"L logger", func() { L(ctxLogger).Info("msg") }, callerLine()},
+		{"With", func() { With(zap.Int("k", 1)).Info("msg") }, callerLine()},
+		{"Named", func() { Named("module").Info("msg") }, callerLine()},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.log()
+			caller := takeOne(t, logs).Caller
+			if !caller.Defined || filepath.Base(caller.File) != "log_test.go" || caller.Line != tt.line {
+				t.Errorf("caller is %s, want log_test.go:%d", caller.TrimmedPath(), tt.line)
+			}
+		})
+	}
+}
+
+// stringer counts how many times it is formatted.
+type stringer struct{ calls int }
+
+func (s *stringer) String() string {
+	s.calls++
+	return "formatted"
+}
+
+func TestSugar(t *testing.T) {
+	logs := observe(t, zapcore.InfoLevel)
+
+	Infof("user %s signed in after %d attempts", "ann", 3)
+	if msg := takeOne(t, logs).Message; msg != "user ann signed in after 3 attempts" {
+		t.Errorf("message is %q", msg)
+	}
+
+	Warnw("signed in", "user", "ann", "attempts", 3)
+	e := takeOne(t, logs)
+	if e.Level != zapcore.WarnLevel || e.Message != "signed in" {
+		t.Errorf("entry is %v %q", e.Level, e.Message)
+	}
+	want := map[string]interface{}{"user": "ann", "attempts": int64(3)}
+	if got := e.ContextMap(); !equalMaps(got, want) {
+		t.Errorf("fields are %v, want %v", got, want)
+	}
+
+	// Disabled entries are not formatted.
+	s := &stringer{}
+	Debugf("%v", s)
+	if s.calls != 0 || logs.Len() != 0 {
+		t.Errorf("debug entry formatted %d times and logged %d times", s.calls, logs.Len())
+	}
+
+	ctx := ContextWithFields(context.Background(), zap.String("request_id", "r1"))
+	ErrorCtxw(ctx, "failed", "error", errors.New("boom"))
+	want = map[string]interface{}{"request_id": "r1", "error": "boom"}
+	if got := takeOne(t, logs).ContextMap(); !equalMaps(got, want) {
+		t.Errorf("fields are %v, want %v", got, want)
+	}
+}
+
+func equalMaps(a, b map[string]interface{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if w, ok := b[k]; !ok || w != v {
+			return false
+		}
+	}
+	return true
+}
+
+func TestContextFields(t *testing.T) {
+	logs := observe(t, zapcore.DebugLevel)
+
+	parent := ContextWithFields(context.Background(), zap.Int("a", 1), zap.Int("b", 2))
+	ctx := ContextWithFields(parent, zap.Int("b", 3))
+
+	// The fields of the log site replace those of ctx.
+	InfoCtx(ctx, "msg", zap.Int("c", 4), zap.Int("a", 5))
+	e := takeOne(t, logs)
+	var keys []string
+	for _, f := range e.Context {
+		keys = append(keys, f.Key)
+	}
+	want := map[string]interface{}{"a": int64(5), "b": int64(3), "c": int64(4)}
+	if got := e.ContextMap(); !equalMaps(got, want) || len(keys) != 3 {
+		t.Errorf("fields are %v (%v), want %v", got, keys, want)
+	}
+
+	// The parent context is not changed.
+	L(parent).Info("msg")
+	want = map[string]interface{}{"a": int64(1), "b": int64(2)}
+	if got := takeOne(t, logs).ContextMap(); !equalMaps(got, want) {
+		t.Errorf("fields are %v, want %v", got, want)
+	}
+
+	// ContextWithLogger drops the fields, the logger has them already.
+	ctx = ContextWithLogger(ctx, With(zap.Int("d", 6)))
+	ctx = ContextWithFields(ctx, zap.Int("e", 7))
+	InfoCtx(ctx, "msg")
+	want = map[string]interface{}{"d": int64(6), "e": int64(7)}
+	if got := takeOne(t, logs).ContextMap(); !equalMaps(got, want) {
+		t.Errorf("fields are %v, want %v", got, want)
+	}
+}
+
+func TestTraceFields(t *testing.T) {
+	logs := observe(t, zapcore.DebugLevel)
+	t.Cleanup(func() { SetTraceFieldNames(DefaultTraceFieldNames) })
+
+	traceID, _ := trace.TraceIDFromHex("0102030405060708090a0b0c0d0e0f10")
+	spanID, _ := trace.SpanIDFromHex("0102030405060708")
+	otelCtx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    traceID,
+		SpanID:     spanID,
+		TraceFlags: trace.FlagsSampled,
+	}))
+
+	tracer := apmtest.NewDiscardTracer()
+	defer tracer.Close()
+	tx := tracer.StartTransaction("request", "test")
+	defer tx.End()
+	tc := tx.TraceContext()
+	apmCtx := apm.ContextWithTransaction(context.Background(), tx)
+
+	tests := []struct {
+		name  string
+		names TraceFieldNames
+		ctx   context.Context
+		want  map[string]interface{}
+	}{
+		{"otel", DefaultTraceFieldNames, otelCtx, map[string]interface{}{
+			"trace.traceid": traceID.String(),
+			"trace.spanid":  spanID.String(),
+			"trace.flags":   "01",
+		}},
+		{"otel names", OTelTraceFieldNames, otelCtx, map[string]interface{}{
+			"trace_id":    traceID.String(),
+			"span_id":     spanID.String(),
+			"trace_flags": "01",
+		}},
+		{"ecs names", ECSTraceFieldNames, otelCtx, map[string]interface{}{
+			"trace.id": traceID.String(),
+			"span.id":  spanID.String(),
+		}},
+		{"apm", DefaultTraceFieldNames, apmCtx, map[string]interface{}{
+			"trace.traceid": tc.Trace.String(),
+			"trace.spanid":  tc.Span.String(),
+		}},
+		{"no trace", DefaultTraceFieldNames, context.Background(), map[string]interface{}{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SetTraceFieldNames(tt.names)
+			InfoCtx(tt.ctx, "msg")
+			if got := takeOne(t, logs).ContextMap(); !equalMaps(got, tt.want) {
+				t.Errorf("InfoCtx fields are %v, want %v", got, tt.want)
+			}
+			L(tt.ctx).Info("msg")
+			if got := takeOne(t, logs).ContextMap(); !equalMaps(got, tt.want) {
+				t.Errorf("L fields are %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGlobalSwapRace(t *testing.T) {
+	core, logs := observer.New(zapcore.DebugLevel)
+	t.Cleanup(ReplaceGlobal(zap.New(core)))
+
+	const writers, entries = 4, 200
+	ctx := ContextWithFields(context.Background(), zap.Int("k", 1))
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < entries; j++ {
+				Info("msg")
+				InfoCtx(ctx, "msg")
+				Infow("msg", "k", j)
+				L(ctx).Info("msg")
+			}
+		}()
+	}
+	for i := 0; i < 50; i++ {
+		restore := ReplaceGlobal(zap.New(core))
+		SetTraceFieldNames(OTelTraceFieldNames)
+		restore()
+		SetTraceFieldNames(DefaultTraceFieldNames)
+	}
+	wg.Wait()
+	if n := logs.Len(); n != writers*entries*4 {
+		t.Errorf("logged %d entries, want %d", n, writers*entries*4)
+	}
+}
@@ -9,69 +9,85 @@ import (
 	"github.com/getsentry/sentry-go"
 	"github.com/liasece/log/encoder"
 	logsentry "github.com/liasece/log/sentry"
-	"go.elastic.co/apm"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 )
 
-var (
-	_globalL *zap.Logger
-)
-
-const (
-	_traceIDKey = "trace.traceid"
-	_spanIDKey  = "trace.spanid"
-)
-
-// L return global logger
+// L return global logger, or the one stored by ContextWithLogger, with the
+// fields stored by ContextWithFields and the trace of ctx if any: the elastic
+// APM span or transaction, else the OpenTelemetry span. See
+// SetTraceFieldNames for the keys of the trace fields. The sentry hub of ctx,
+// set by sentry.SetHubOnContext, is used to report the entries of the logger
+// to sentry.
+//
+// The logger reports the caller of its methods. Use the *Ctx functions such
+// as InfoCtx to log a single entry.
 func L(ctx context.Context) *zap.Logger {
 	if ctx == nil {
-		return _globalL
+		return global().l
 	}
-
-	span := apm.SpanFromContext(ctx)
-
-	if span != nil {
-		return withTraceContext(span.TraceContext())
+	logger := global().l
+	var fields []zap.Field
+	if c := fromContext(ctx); c != nil {
+		if c.logger != nil {
+			logger = c.logger
+		}
+		fields = c.fields
 	}
-
-	tx := apm.TransactionFromContext(ctx)
-	if tx != nil {
-		return withTraceContext(tx.TraceContext())
+	fields = mergeFields(fields, contextFields(ctx))
+	if len(fields) == 0 {
+		return logger
 	}
-
-	return _globalL
+	return logger.With(fields...)
 }
 
-func withTraceContext(tc apm.TraceContext) *zap.Logger {
-	return _globalL.With(
-		zap.String(_traceIDKey, tc.Trace.String()),
-		zap.String(_spanIDKey, tc.Span.String()),
-	)
+// checkCtx checks an entry of the logger of ctx, and returns the fields of
+// ctx if it is to be written. It must be called directly by the *Ctx
+// functions for the caller to be right.
+func checkCtx(ctx context.Context, lvl zapcore.Level, msg string, fields []zap.Field) (*zapcore.CheckedEntry, []zap.Field) {
+	g := global()
+	if ctx == nil {
+		return g.ctxSkipped.Check(lvl, msg), fields
+	}
+	logger := g.ctxSkipped
+	c := fromContext(ctx)
+	if c != nil && c.logger != nil {
+		logger = c.logger.WithOptions(zap.AddCallerSkip(2))
+	}
+	ce := logger.Check(lvl, msg)
+	if ce == nil {
+		return nil, nil
+	}
+	var ctxFields []zap.Field
+	if c != nil {
+		ctxFields = c.fields
+	}
+	ctxFields = mergeFields(ctxFields, contextFields(ctx))
+	return ce, mergeFields(ctxFields, fields)
 }
 
 // Debug logs a message at DebugLevel. The message includes any fields passed
 // at the log site, as well as any fields accumulated on the logger.
 func Debug(msg string, fields ...zap.Field) {
-	_globalL.Debug(msg, fields...)
+	global().skipped.Debug(msg, fields...)
 }
 
 // Info logs a message at InfoLevel. The message includes any fields passed
 // at the log site, as well as any fields accumulated on the logger.
 func Info(msg string, fields ...zap.Field) {
-	_globalL.Info(msg, fields...)
+	global().skipped.Info(msg, fields...)
 }
 
 // Warn logs a message at WarnLevel. The message includes any fields passed
 // at the log site, as well as any fields accumulated on the logger.
 func Warn(msg string, fields ...zap.Field) {
-	_globalL.Warn(msg, fields...)
+	global().skipped.Warn(msg, fields...)
 }
 
 // Error logs a message at ErrorLevel. The message includes any fields passed
 // at the log site, as well as any fields accumulated on the logger.
 func Error(msg string, fields ...zap.Field) {
-	_globalL.Error(msg, fields...)
+	global().skipped.Error(msg, fields...)
 }
 
 // DPanic logs a message at DPanicLevel. The message includes any fields
@@ -81,7 +97,7 @@ func Error(msg string, fields ...zap.Field) {
 // "development panic"). This is useful for catching errors that are
 // recoverable, but shouldn't ever happen.
 func DPanic(msg string, fields ...zap.Field) {
-	_globalL.DPanic(msg, fields...)
+	global().skipped.DPanic(msg, fields...)
 }
 
 // Panic logs a message at PanicLevel. The message includes any fields passed
@@ -89,7 +105,7 @@ func DPanic(msg string, fields ...zap.Field) {
 //
 // The logger then panics, even if logging at PanicLevel is disabled.
 func Panic(msg string, fields ...zap.Field) {
-	_globalL.Panic(msg, fields...)
+	global().skipped.Panic(msg, fields...)
 }
 
 // Fatal logs a message at FatalLevel. The message includes any fields passed
@@ -98,40 +114,84 @@ func Panic(msg string, fields ...zap.Field) {
 // The logger then calls os.Exit(1), even if logging at FatalLevel is
 // disabled.
 func Fatal(msg string, fields ...zap.Field) {
-	_globalL.Fatal(msg, fields...)
+	global().skipped.Fatal(msg, fields...)
+}
+
+// DebugCtx logs a message at DebugLevel with the logger, fields and trace
+// of ctx, see L. The message includes any fields passed at the log site.
+func DebugCtx(ctx context.Context, msg string, fields ...zap.Field) {
+	if ce, fs := checkCtx(ctx, zapcore.DebugLevel, msg, fields); ce != nil {
+		ce.Write(fs...)
+	}
+}
+
+// InfoCtx logs a message at InfoLevel with the logger, fields and trace of
+// ctx, see L. The message includes any fields passed at the log site.
+func InfoCtx(ctx context.Context, msg string, fields ...zap.Field) {
+	if ce, fs := checkCtx(ctx, zapcore.InfoLevel, msg, fields); ce != nil {
+		ce.Write(fs...)
+	}
+}
+
+// WarnCtx logs a message at WarnLevel with the logger, fields and trace of
+// ctx, see L. The message includes any fields passed at the log site.
+func WarnCtx(ctx context.Context, msg string, fields ...zap.Field) {
+	if ce, fs := checkCtx(ctx, zapcore.WarnLevel, msg, fields); ce != nil {
+		ce.Write(fs...)
+	}
+}
+
+// ErrorCtx logs a message at ErrorLevel with the logger, fields and trace
+// of ctx, see L. The message includes any fields passed at the log site.
+func ErrorCtx(ctx context.Context, msg string, fields ...zap.Field) {
+	if ce, fs := checkCtx(ctx, zapcore.ErrorLevel, msg, fields); ce != nil {
+		ce.Write(fs...)
+	}
+}
+
+// DPanicCtx logs a message at DPanicLevel with the logger, fields and trace
+// of ctx, see L. The message includes any fields passed at the log site.
+//
+// If the logger is in development mode, it then panics.
+func DPanicCtx(ctx context.Context, msg string, fields ...zap.Field) {
+	if ce, fs := checkCtx(ctx, zapcore.DPanicLevel, msg, fields); ce != nil {
+		ce.Write(fs...)
+	}
+}
+
+// PanicCtx logs a message at PanicLevel with the logger, fields and trace
+// of ctx, see L. The message includes any fields passed at the log site.
+//
+// The logger then panics, even if logging at PanicLevel is disabled.
+func PanicCtx(ctx context.Context, msg string, fields ...zap.Field) {
+	if ce, fs := checkCtx(ctx, zapcore.PanicLevel, msg, fields); ce != nil {
+		ce.Write(fs...)
+	}
+}
+
+// FatalCtx logs a message at FatalLevel with the logger, fields and trace
+// of ctx, see L. The message includes any fields passed at the log site.
+//
+// The logger then calls os.Exit(1), even if logging at FatalLevel is
+// disabled.
+func FatalCtx(ctx context.Context, msg string, fields ...zap.Field) {
+	if ce, fs := checkCtx(ctx, zapcore.FatalLevel, msg, fields); ce != nil {
+		ce.Write(fs...)
+	}
 }
 
 // With creates a child logger and adds structured context to it. Fields added
 // to the child don't affect the parent, and vice versa.
 func With(fields ...zap.Field) *zap.Logger {
-	return _globalL.With(fields...)
+	return global().l.With(fields...)
 }
 
 // Sync flushes buffered logs (if any).
 func Sync() error {
-	return _globalL.Core().Sync()
+	return global().l.Core().Sync()
 }
 
-func getZapLevelEnablerFunc(level string) zapcore.Level {
-	switch level {
-	case "debug":
-		return zapcore.DebugLevel
-	case "warn":
-		return zapcore.WarnLevel
-	case "error":
-		return zapcore.ErrorLevel
-	case "fatal":
-		return zapcore.FatalLevel
-	case "panic":
-		return zapcore.PanicLevel
-	case "info":
-		return zapcore.InfoLevel
-	default:
-		return zapcore.DebugLevel
-	}
-}
-
-func getConsoleCore(colorLevel bool, level string) (zapcore.Core, error) {
+func getConsoleEncoderConfig(colorLevel bool) zapcore.EncoderConfig {
 	consoleEncoder := zap.NewProductionEncoderConfig()
 	consoleEncoder.EncodeTime = func(t time.Time, enc zapcore.PrimitiveArrayEncoder) {
 		enc.AppendString("[")
@@ -140,22 +200,21 @@ func getConsoleCore(colorLevel bool, level string) (zapcore.Core, error) {
 	}
 	consoleEncoder.EncodeLevel = encoder.MyLevelEncoder
 	consoleEncoder.EncodeCaller = encoder.MyCallerEncode
+	consoleEncoder.EncodeName = encoder.MyNameEncoder
 
 	if colorLevel {
 		consoleEncoder.EncodeLevel = encoder.MyColorLevelEncoder
 	}
+	return consoleEncoder
+}
 
-	return zapcore.NewCore(encoder.NewConsoleEncoder(consoleEncoder), zapcore.Lock(os.Stdout), getZapLevelEnablerFunc(level)), nil
+func getJSONEncoderConfig() zapcore.EncoderConfig {
+	jsonEncoder := zap.NewProductionEncoderConfig()
+	jsonEncoder.EncodeTime = zapcore.ISO8601TimeEncoder
+	return jsonEncoder
 }
 
-func getSentryCore(options sentry.ClientOptions) (zapcore.Core, error) {
-	cfg := logsentry.Configuration{
-		Level: zapcore.ErrorLevel, //when to send message to sentry
-		Tags: map[string]string{
-			"component": "system",
-		},
-		FlushTimeout: time.Second * 5,
-	}
+func getSentryCore(options sentry.ClientOptions, cfg logsentry.Configuration) (zapcore.Core, error) {
 	core, err := logsentry.NewCore(cfg, logsentry.NewSentryClientFromOptions(options))
 	//in case of err it will return noop core. so we can safely attach it
 	if err != nil {
@@ -164,22 +223,21 @@ func getSentryCore(options sentry.ClientOptions) (zapcore.Core, error) {
 	return core, err
 }
 
-func initZapLogger(level string) (err error) {
-	consoleCore, err := getConsoleCore(true, level)
+func initZapLogger(cfg *Config, fileName string) (err error) {
+	state, err := newLogState(cfg, fileName)
 	if err != nil {
 		return
 	}
 
-	_globalL = zap.New(zapcore.NewTee(consoleCore), zap.AddCaller(), zap.AddCallerSkip(1))
+	cfg.applyTraceFields()
+	replaceGlobal(newGlobalLogger(state.logger(), state))
 	return
 }
 
 func init() {
-	consoleCore, err := getConsoleCore(true, "debug")
-	encoder.CheckIfTerminal(os.Stdout)
-	if err == nil {
-		_globalL = zap.New(zapcore.NewTee(consoleCore), zap.AddCaller(), zap.AddCallerSkip(1))
-	} else {
+	// The default logger writes everything to stdout, and can be changed at
+	// runtime like the one built by InitLog.
+	if err := initZapLogger(&Config{}, ""); err != nil {
 		panic(err)
 	}
 }
@@ -0,0 +1,169 @@
+package logloki
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	// LevelLabel is the label of the level of the entries, if listed in
+	// Configuration.Labels and no field has this key.
+	LevelLabel = "level"
+	// LoggerLabel is the label of the name of the logger, if listed in
+	// Configuration.Labels and no field has this key.
+	LoggerLabel = "logger"
+	// JobLabel is the label of the entries without labels, the name of the
+	// executable, as Loki requires one.
+	JobLabel = "job"
+)
+
+var defaultJob = filepath.Base(os.Args[0])
+
+// NewCore creates a zap core that encodes the entries with enc and pushes
+// them through w. The entries are grouped into streams by the values of the
+// fields listed in Configuration.Labels, outside of namespaces; the label
+// names are the keys with the characters other than letters, digits and
+// underscores replaced by underscores. Entries without labels get JobLabel.
+// The fields are encoded in the lines too. The entries above ErrorLevel are
+// pushed before Write returns, as the program may be about to exit, unless a
+// batch waits to be retried, see Writer.Sync.
+func NewCore(enc zapcore.Encoder, w *Writer, enab zapcore.LevelEnabler) zapcore.Core {
+	return &core{LevelEnabler: enab, enc: enc, w: w}
+}
+
+type core struct {
+	zapcore.LevelEnabler
+	enc zapcore.Encoder
+	w   *Writer
+	// labels are the labels of the fields added by With.
+	labels labelSet
+	// nested is true once With added a namespace.
+	nested bool
+}
+
+func (c *core) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.enc = c.enc.Clone()
+	for i := range fields {
+		fields[i].AddTo(clone.enc)
+	}
+	clone.labels, clone.nested = c.addLabels(c.labels, c.nested, fields)
+	return &clone
+}
+
+func (c *core) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *core) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	labels, _ := c.addLabels(c.labels, c.nested, fields)
+	for _, name := range c.w.cfg.Labels {
+		switch name {
+		case LevelLabel:
+			labels = setLabel(labels, LevelLabel, ent.Level.String(), false)
+		case LoggerLabel:
+			if ent.LoggerName != "" {
+				labels = setLabel(labels, LoggerLabel, ent.LoggerName, false)
+			}
+		}
+	}
+	if len(labels) == 0 {
+		labels = labelSet{{name: JobLabel, value: defaultJob}}
+	}
+
+	buf, err := c.enc.EncodeEntry(ent, fields)
+	if err != nil {
+		return err
+	}
+	line := strings.TrimSuffix(buf.String(), "\n")
+	buf.Free()
+	c.w.add(labels, entry{ts: ent.Time, line: line})
+	if ent.Level > zapcore.ErrorLevel {
+		return c.w.Sync()
+	}
+	return nil
+}
+
+func (c *core) Sync() error {
+	return c.w.Sync()
+}
+
+// addLabels returns ls with the labels of fields, up to the first
+// namespace.
+func (c *core) addLabels(ls labelSet, nested bool, fields []zapcore.Field) (labelSet, bool) {
+	for _, f := range fields {
+		if nested {
+			break
+		}
+		if f.Type == zapcore.NamespaceType {
+			nested = true
+			break
+		}
+		if !c.isLabel(f.Key) {
+			continue
+		}
+		enc := zapcore.NewMapObjectEncoder()
+		f.AddTo(enc)
+		v, ok := enc.Fields[f.Key]
+		if !ok {
+			continue
+		}
+		ls = setLabel(ls, labelName(f.Key), fmt.Sprint(v), true)
+	}
+	return ls, nested
+}
+
+func (c *core) isLabel(key string) bool {
+	for _, l := range c.w.cfg.Labels {
+		if l == key {
+			return true
+		}
+	}
+	return false
+}
+
+// setLabel sets a label of ls, or inserts it in order. ls is copied rather
+// than modified. An existing label is only replaced if replace is true.
+func setLabel(ls labelSet, name, value string, replace bool) labelSet {
+	i := sort.Search(len(ls), func(i int) bool { return ls[i].name >= name })
+	if i < len(ls) && ls[i].name == name && !replace {
+		return ls
+	}
+	copied := make(labelSet, 0, len(ls)+1)
+	copied = append(copied, ls[:i]...)
+	copied = append(copied, label{name: name, value: value})
+	if i < len(ls) && ls[i].name == name {
+		i++
+	}
+	return append(copied, ls[i:]...)
+}
+
+// labelName returns key as a valid label name.
+func labelName(key string) string {
+	var sb strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '_':
+		case c >= '0' && c <= '9':
+			if i == 0 {
+				sb.WriteByte('_')
+			}
+		default:
+			c = '_'
+		}
+		sb.WriteByte(c)
+	}
+	if sb.Len() == 0 {
+		return "_"
+	}
+	return sb.String()
+}
@@ -0,0 +1,160 @@
+package logloki
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/golang/snappy"
+)
+
+// label is a label of a stream.
+type label struct {
+	name, value string
+}
+
+// labelSet are the labels of a stream, sorted by name.
+type labelSet []label
+
+// String returns the labels in the Prometheus format, {name="value", ...}.
+func (ls labelSet) String() string {
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i, l := range ls {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(l.name)
+		sb.WriteByte('=')
+		sb.WriteByte('"')
+		sb.WriteString(labelValueReplacer.Replace(l.value))
+		sb.WriteByte('"')
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+// labelValueReplacer escapes label values as the Prometheus format does,
+// which knows no other escape sequences.
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+type entry struct {
+	ts   time.Time
+	line string
+}
+
+type stream struct {
+	labels  labelSet
+	entries []entry
+}
+
+// streams groups the entries of a batch by labels, in the order of the
+// first entry of each stream.
+func streams(batch []queued) []*stream {
+	var ss []*stream
+	index := make(map[string]*stream)
+	for _, q := range batch {
+		s := index[q.key]
+		if s == nil {
+			s = &stream{labels: q.labels}
+			index[q.key] = s
+			ss = append(ss, s)
+		}
+		s.entries = append(s.entries, q.entry)
+	}
+	return ss
+}
+
+// encodeJSON returns the gzip compressed JSON push request.
+func encodeJSON(ss []*stream) ([]byte, error) {
+	type jsonStream struct {
+		Stream map[string]string `json:"stream"`
+		Values [][2]string       `json:"values"`
+	}
+	req := struct {
+		Streams []jsonStream `json:"streams"`
+	}{make([]jsonStream, 0, len(ss))}
+	for _, s := range ss {
+		js := jsonStream{
+			Stream: make(map[string]string, len(s.labels)),
+			Values: make([][2]string, 0, len(s.entries)),
+		}
+		for _, l := range s.labels {
+			js.Stream[l.name] = l.value
+		}
+		for _, e := range s.entries {
+			js.Values = append(js.Values, [2]string{strconv.FormatInt(e.ts.UnixNano(), 10), e.line})
+		}
+		req.Streams = append(req.Streams, js)
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := json.NewEncoder(zw).Encode(req); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeProtobuf returns the snappy compressed protobuf push request, see
+// logproto.PushRequest in the Loki repository:
+//
+//	message PushRequest { repeated Stream streams = 1; }
+//	message Stream { string labels = 1; repeated Entry entries = 2; }
+//	message Entry { google.protobuf.Timestamp timestamp = 1; string line = 2; }
+//	message Timestamp { int64 seconds = 1; int32 nanos = 2; }
+func encodeProtobuf(ss []*stream) []byte {
+	var req, st, ent, ts []byte
+	for _, s := range ss {
+		st = appendString(st[:0], 1, s.labels.String())
+		for _, e := range s.entries {
+			ts = ts[:0]
+			if sec := e.ts.Unix(); sec != 0 {
+				ts = appendVarintField(ts, 1, uint64(sec))
+			}
+			if nsec := e.ts.Nanosecond(); nsec != 0 {
+				ts = appendVarintField(ts, 2, uint64(nsec))
+			}
+			ent = appendBytes(ent[:0], 1, ts)
+			ent = appendString(ent, 2, e.line)
+			st = appendBytes(st, 2, ent)
+		}
+		req = appendBytes(req, 1, st)
+	}
+	return snappy.Encode(nil, req)
+}
+
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+func appendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func appendVarintField(b []byte, field int, v uint64) []byte {
+	b = appendVarint(b, uint64(field)<<3|wireVarint)
+	return appendVarint(b, v)
+}
+
+func appendBytes(b []byte, field int, v []byte) []byte {
+	b = appendVarint(b, uint64(field)<<3|wireBytes)
+	b = appendVarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func appendString(b []byte, field int, v string) []byte {
+	b = appendVarint(b, uint64(field)<<3|wireBytes)
+	b = appendVarint(b, uint64(len(v)))
+	return append(b, v...)
+}
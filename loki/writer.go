@@ -0,0 +1,437 @@
+package logloki
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Format is the payload of the push requests.
+type Format int
+
+const (
+	// FormatProtobuf payloads are snappy compressed protobuf, as sent by
+	// Promtail.
+	FormatProtobuf Format = iota
+	// FormatJSON payloads are gzip compressed JSON.
+	FormatJSON
+)
+
+var formatNames = []string{"protobuf", "json"}
+
+func (f Format) String() string {
+	if f >= 0 && int(f) < len(formatNames) {
+		return formatNames[f]
+	}
+	return fmt.Sprintf("Format(%d)", int(f))
+}
+
+// UnmarshalText unmarshals the name of a format, such as "json".
+func (f *Format) UnmarshalText(text []byte) error {
+	name := strings.ToLower(string(text))
+	for i, n := range formatNames {
+		if n == name {
+			*f = Format(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unrecognized format: %q", text)
+}
+
+const (
+	// DefaultBatchSize is the number of entries pushed at once by default.
+	DefaultBatchSize = 1000
+	// DefaultBatchWait is how long entries wait for a batch by default.
+	DefaultBatchWait = time.Second
+	// DefaultTimeout is the default timeout of a push request.
+	DefaultTimeout = 10 * time.Second
+	// DefaultMinBackoff is the first delay before retrying by default.
+	DefaultMinBackoff = 500 * time.Millisecond
+	// DefaultMaxBackoff is the longest delay before retrying by default.
+	DefaultMaxBackoff = time.Minute
+	// DefaultMaxRetries is how many times a batch is retried by default.
+	DefaultMaxRetries = 10
+	// DefaultQueueSize is the number of entries buffered by default.
+	DefaultQueueSize = 10000
+)
+
+// Configuration is the set of parameters of a Loki writer.
+type Configuration struct {
+	// URL is the push endpoint, such as
+	// http://localhost:3100/loki/api/v1/push.
+	URL string
+	// Format is the payload of the requests.
+	Format Format
+	// TenantID is sent as X-Scope-OrgID if not empty.
+	TenantID string
+	// Headers are added to the requests, such as Authorization.
+	Headers map[string]string
+	// BatchSize is the maximum number of entries pushed at once,
+	// DefaultBatchSize if zero.
+	BatchSize int
+	// BatchWait is how long entries wait for a full batch, DefaultBatchWait
+	// if zero.
+	BatchWait time.Duration
+	// Timeout bounds a push request, DefaultTimeout if zero.
+	Timeout time.Duration
+	// MinBackoff and MaxBackoff bound the delay before retrying, which
+	// doubles with every failure. DefaultMinBackoff and DefaultMaxBackoff if
+	// zero.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// MaxRetries is how many times a batch is retried before it is dropped,
+	// DefaultMaxRetries if zero.
+	MaxRetries int
+	// QueueSize is the maximum number of buffered entries, DefaultQueueSize
+	// if zero. The entries written while it is full are dropped.
+	QueueSize int
+	// Labels are the keys of the fields whose values label the streams, see
+	// NewCore.
+	Labels []string
+}
+
+// Stats are the counters of a Writer.
+type Stats struct {
+	// Queued is the number of entries waiting to be pushed.
+	Queued int
+	// Sent is the number of entries accepted by Loki.
+	Sent uint64
+	// Failures is the number of failed push requests.
+	Failures uint64
+	// Dropped is the number of entries lost because the queue was full, Loki
+	// rejected them or the retries ran out.
+	Dropped uint64
+}
+
+type queued struct {
+	key    string // labels.String()
+	labels labelSet
+	entry
+}
+
+// Writer pushes entries to Loki in batches from a goroutine, retrying on
+// network errors, 429 and 5xx responses with a backoff. The entries written
+// meanwhile wait behind the failed batch. Use NewCore to write entries.
+type Writer struct {
+	cfg    Configuration
+	client *http.Client
+
+	mu     sync.Mutex
+	queue  []queued
+	closed bool
+
+	// retries is the number of failures of the batch at the front of the
+	// queue, used by run.
+	retries int
+
+	sent     uint64 // atomic
+	failures uint64 // atomic
+	dropped  uint64 // atomic
+
+	wake  chan struct{}
+	flush chan chan error
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// NewWriter starts pushing to Loki in the background. Close stops it.
+func NewWriter(cfg Configuration) (*Writer, error) {
+	if cfg.URL == "" {
+		return nil, errors.New("logloki: empty url")
+	}
+	if cfg.Format != FormatProtobuf && cfg.Format != FormatJSON {
+		return nil, fmt.Errorf("logloki: unknown format %v", cfg.Format)
+	}
+	if cfg.BatchSize < 0 || cfg.BatchWait < 0 || cfg.Timeout < 0 || cfg.MinBackoff < 0 ||
+		cfg.MaxBackoff < 0 || cfg.MaxRetries < 0 || cfg.QueueSize < 0 {
+		return nil, errors.New("logloki: negative limits are not allowed")
+	}
+	if cfg.BatchSize == 0 {
+		cfg.BatchSize = DefaultBatchSize
+	}
+	if cfg.BatchWait == 0 {
+		cfg.BatchWait = DefaultBatchWait
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	if cfg.MinBackoff == 0 {
+		cfg.MinBackoff = DefaultMinBackoff
+	}
+	if cfg.MaxBackoff == 0 {
+		cfg.MaxBackoff = DefaultMaxBackoff
+	}
+	if cfg.MaxBackoff < cfg.MinBackoff {
+		cfg.MaxBackoff = cfg.MinBackoff
+	}
+	if cfg.MaxRetries == 0 {
+		cfg.MaxRetries = DefaultMaxRetries
+	}
+	if cfg.QueueSize == 0 {
+		cfg.QueueSize = DefaultQueueSize
+	}
+	w := &Writer{
+		cfg:    cfg,
+		client: &http.Client{Timeout: cfg.Timeout},
+		wake:   make(chan struct{}, 1),
+		flush:  make(chan chan error),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go w.run()
+	return w, nil
+}
+
+// add queues an entry of the stream with labels.
+func (w *Writer) add(labels labelSet, e entry) {
+	w.mu.Lock()
+	if w.closed || len(w.queue) >= w.cfg.QueueSize {
+		w.mu.Unlock()
+		atomic.AddUint64(&w.dropped, 1)
+		return
+	}
+	w.queue = append(w.queue, queued{key: labels.String(), labels: labels, entry: e})
+	full := len(w.queue) >= w.cfg.BatchSize
+	w.mu.Unlock()
+
+	if full {
+		select {
+		case w.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (w *Writer) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.cfg.BatchWait)
+	defer ticker.Stop()
+	var retry <-chan time.Time // not nil while a batch waits to be retried
+	var backoff time.Duration
+	var pending error // of the batch waiting to be retried
+	attempt := func(all bool) error {
+		requeued, err := w.push(all, false)
+		if requeued == nil {
+			retry, backoff, pending = nil, 0, nil
+			return err
+		}
+		pending = err
+		backoff = w.nextBackoff(backoff)
+		wait := backoff
+		if requeued.retryAfter > wait {
+			wait = requeued.retryAfter
+		}
+		retry = time.After(wait)
+		return err
+	}
+	for {
+		select {
+		case <-w.wake:
+			if retry == nil {
+				_ = attempt(false)
+			}
+		case <-ticker.C:
+			if retry == nil {
+				_ = attempt(true)
+			}
+		case <-retry:
+			_ = attempt(true)
+		case reply := <-w.flush:
+			// Pushing now would use up a retry of the batch before its
+			// backoff.
+			if retry != nil {
+				reply <- pending
+			} else {
+				reply <- attempt(true)
+			}
+		case <-w.stop:
+			// The retries are not waited for anymore.
+			_, _ = w.push(true, true)
+			return
+		}
+	}
+}
+
+// push sends the queued entries in batches, full batches only unless all is
+// true, once each. Unless final is true, a batch that failed with a
+// retryable error and has retries left is put back at the front of the
+// queue, and push stops and returns its error to retry later. The other
+// failed batches are dropped. push returns the error of the last failed
+// batch.
+func (w *Writer) push(all, final bool) (requeued *retryableError, lastErr error) {
+	for {
+		w.mu.Lock()
+		n := len(w.queue)
+		if n == 0 || (!all && n < w.cfg.BatchSize) {
+			w.mu.Unlock()
+			return nil, lastErr
+		}
+		if n > w.cfg.BatchSize {
+			n = w.cfg.BatchSize
+		}
+		batch := w.queue[:n:n]
+		w.queue = w.queue[n:]
+		w.mu.Unlock()
+
+		err := w.send(batch)
+		if err == nil {
+			w.retries = 0
+			continue
+		}
+		lastErr = err
+		var retryable *retryableError
+		if !final && errors.As(err, &retryable) && w.retries < w.cfg.MaxRetries {
+			w.retries++
+			w.mu.Lock()
+			w.queue = append(batch, w.queue...)
+			w.mu.Unlock()
+			return retryable, err
+		}
+		w.retries = 0
+		_, _ = fmt.Fprintf(os.Stderr, "logloki: dropped %d entries [%v]\n", len(batch), err)
+		atomic.AddUint64(&w.dropped, uint64(len(batch)))
+	}
+}
+
+// retryableError is a failed request that may succeed later.
+type retryableError struct {
+	err        error
+	retryAfter time.Duration
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+// send pushes a batch once.
+func (w *Writer) send(batch []queued) error {
+	ss := streams(batch)
+	var body []byte
+	var err error
+	switch w.cfg.Format {
+	case FormatJSON:
+		body, err = encodeJSON(ss)
+		if err != nil {
+			return fmt.Errorf("logloki: %w", err)
+		}
+	default:
+		body = encodeProtobuf(ss)
+	}
+	if err := w.request(body); err != nil {
+		atomic.AddUint64(&w.failures, 1)
+		return err
+	}
+	atomic.AddUint64(&w.sent, uint64(len(batch)))
+	return nil
+}
+
+func (w *Writer) request(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, w.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("logloki: %w", err)
+	}
+	switch w.cfg.Format {
+	case FormatJSON:
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Content-Encoding", "gzip")
+	default:
+		req.Header.Set("Content-Type", "application/x-protobuf")
+	}
+	if w.cfg.TenantID != "" {
+		req.Header.Set("X-Scope-OrgID", w.cfg.TenantID)
+	}
+	for k, v := range w.cfg.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return &retryableError{err: fmt.Errorf("logloki: %w", err)}
+	}
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	_ = resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	err = fmt.Errorf("logloki: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+		return &retryableError{err: err, retryAfter: retryAfter(resp.Header.Get("Retry-After"))}
+	}
+	return err
+}
+
+// retryAfter parses the value of a Retry-After header, a number of seconds or
+// an HTTP date.
+func retryAfter(value string) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		return time.Until(t)
+	}
+	return 0
+}
+
+func (w *Writer) nextBackoff(backoff time.Duration) time.Duration {
+	backoff *= 2
+	if backoff < w.cfg.MinBackoff {
+		backoff = w.cfg.MinBackoff
+	}
+	if backoff > w.cfg.MaxBackoff {
+		backoff = w.cfg.MaxBackoff
+	}
+	// Up to 20% of jitter, so that the writers don't retry together.
+	return backoff + time.Duration(rand.Int63n(int64(backoff)/5+1))
+}
+
+// Sync pushes the queued entries without waiting to retry: a batch that
+// failed with a retryable error stays queued and is retried in the
+// background. It returns the error of the last failed batch. While a batch
+// waits to be retried, Sync pushes nothing and returns its error.
+func (w *Writer) Sync() error {
+	reply := make(chan error, 1)
+	select {
+	case w.flush <- reply:
+		return <-reply
+	case <-w.done:
+		return nil
+	}
+}
+
+// Close stops retrying, pushes the queued entries without retries and stops
+// the writer. The entries written afterwards are dropped.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	w.mu.Unlock()
+
+	close(w.stop)
+	<-w.done
+	w.client.CloseIdleConnections()
+	return nil
+}
+
+// Stats returns the counters of the writer.
+func (w *Writer) Stats() Stats {
+	w.mu.Lock()
+	queued := len(w.queue)
+	w.mu.Unlock()
+	return Stats{
+		Queued:   queued,
+		Sent:     atomic.LoadUint64(&w.sent),
+		Failures: atomic.LoadUint64(&w.failures),
+		Dropped:  atomic.LoadUint64(&w.dropped),
+	}
+}
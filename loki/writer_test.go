@@ -0,0 +1,480 @@
+package logloki
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/snappy"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// pushedStream is a stream of a push request, decoded.
+type pushedStream struct {
+	labels string
+	ts     []int64
+	lines  []string
+}
+
+type pushRequest struct {
+	header  http.Header
+	streams []pushedStream
+}
+
+// standIn is a stand-in Loki, answering with statuses before succeeding.
+type standIn struct {
+	*httptest.Server
+	t        *testing.T
+	mu       sync.Mutex
+	statuses []int // of the next requests, then 204
+	failing  int   // answered to every request if not zero
+	requests []pushRequest
+	failures int
+}
+
+func newStandIn(t *testing.T) *standIn {
+	t.Helper()
+	s := &standIn{t: t}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *standIn) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := http.StatusNoContent
+	if s.failing != 0 {
+		status = s.failing
+	} else if len(s.statuses) > 0 {
+		status, s.statuses = s.statuses[0], s.statuses[1:]
+	}
+	if status != http.StatusNoContent {
+		s.failures++
+		w.WriteHeader(status)
+		return
+	}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		s.t.Error(err)
+	}
+	var streams []pushedStream
+	if r.Header.Get("Content-Type") == "application/json" {
+		streams = decodeJSON(s.t, body)
+	} else {
+		streams = decodeProtobuf(s.t, body)
+	}
+	s.requests = append(s.requests, pushRequest{header: r.Header, streams: streams})
+	w.WriteHeader(status)
+}
+
+func (s *standIn) pushed() []pushRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]pushRequest(nil), s.requests...)
+}
+
+func decodeJSON(t *testing.T, body []byte) []pushedStream {
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		t.Error(err)
+		return nil
+	}
+	var req struct {
+		Streams []struct {
+			Stream map[string]string `json:"stream"`
+			Values [][2]string       `json:"values"`
+		} `json:"streams"`
+	}
+	if err := json.NewDecoder(zr).Decode(&req); err != nil {
+		t.Error(err)
+		return nil
+	}
+	var streams []pushedStream
+	for _, js := range req.Streams {
+		var ls labelSet
+		for name, value := range js.Stream {
+			ls = setLabel(ls, name, value, true)
+		}
+		s := pushedStream{labels: ls.String()}
+		for _, v := range js.Values {
+			ts, _ := strconv.ParseInt(v[0], 10, 64)
+			s.ts = append(s.ts, ts)
+			s.lines = append(s.lines, v[1])
+		}
+		streams = append(streams, s)
+	}
+	return streams
+}
+
+// protoField is a field of a protobuf message, a varint or bytes.
+type protoField struct {
+	num    int
+	varint uint64
+	bytes  []byte
+}
+
+func protoFields(t *testing.T, b []byte) []protoField {
+	var fields []protoField
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		b = b[n:]
+		f := protoField{num: int(key >> 3)}
+		switch key & 7 {
+		case wireVarint:
+			f.varint, n = binary.Uvarint(b)
+			b = b[n:]
+		case wireBytes:
+			size, n := binary.Uvarint(b)
+			f.bytes, b = b[n:n+int(size)], b[n+int(size):]
+		default:
+			t.Errorf("unexpected wire type %d", key&7)
+			return fields
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+func decodeProtobuf(t *testing.T, body []byte) []pushedStream {
+	req, err := snappy.Decode(nil, body)
+	if err != nil {
+		t.Error(err)
+		return nil
+	}
+	var streams []pushedStream
+	for _, sf := range protoFields(t, req) {
+		var s pushedStream
+		for _, f := range protoFields(t, sf.bytes) {
+			switch f.num {
+			case 1:
+				s.labels = string(f.bytes)
+			case 2:
+				var sec, nsec int64
+				var line string
+				for _, ef := range protoFields(t, f.bytes) {
+					switch ef.num {
+					case 1:
+						for _, tf := range protoFields(t, ef.bytes) {
+							if tf.num == 1 {
+								sec = int64(tf.varint)
+							} else {
+								nsec = int64(tf.varint)
+							}
+						}
+					case 2:
+						line = string(ef.bytes)
+					}
+				}
+				s.ts = append(s.ts, sec*1e9+nsec)
+				s.lines = append(s.lines, line)
+			}
+		}
+		streams = append(streams, s)
+	}
+	return streams
+}
+
+func newTestWriter(t *testing.T, cfg Configuration) *Writer {
+	t.Helper()
+	if cfg.MinBackoff == 0 {
+		cfg.MinBackoff, cfg.MaxBackoff = 10*time.Millisecond, 20*time.Millisecond
+	}
+	if cfg.BatchWait == 0 {
+		cfg.BatchWait = time.Hour
+	}
+	w, err := NewWriter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = w.Close() })
+	return w
+}
+
+// lineEncoder encodes the entries as their message.
+func lineEncoder() zapcore.Encoder {
+	return zapcore.NewConsoleEncoder(zapcore.EncoderConfig{MessageKey: "msg"})
+}
+
+var testTime = time.Date(2021, 2, 3, 4, 5, 6, 789, time.UTC)
+
+func write(t *testing.T, core zapcore.Core, level zapcore.Level, msg string, fields ...zap.Field) {
+	t.Helper()
+	if err := core.Write(zapcore.Entry{Level: level, Time: testTime, Message: msg}, fields); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWriterStreams(t *testing.T) {
+	for _, format := range []Format{FormatProtobuf, FormatJSON} {
+		t.Run(format.String(), func(t *testing.T) {
+			s := newStandIn(t)
+			w := newTestWriter(t, Configuration{
+				URL:      s.URL,
+				Format:   format,
+				TenantID: "tenant",
+				Headers:  map[string]string{"Authorization": "Bearer token"},
+				Labels:   []string{"app", LevelLabel},
+			})
+			core := NewCore(lineEncoder(), w, zapcore.DebugLevel)
+			api := core.With([]zap.Field{zap.String("app", "api")})
+			write(t, api, zapcore.InfoLevel, "one")
+			write(t, core, zapcore.InfoLevel, "two", zap.String("app", `web "ui"`))
+			write(t, api, zapcore.WarnLevel, "three")
+			write(t, api, zapcore.InfoLevel, "four")
+			if err := w.Sync(); err != nil {
+				t.Fatal(err)
+			}
+
+			pushed := s.pushed()
+			if len(pushed) != 1 {
+				t.Fatalf("got %d requests, want 1", len(pushed))
+			}
+			// The fields are in the lines too.
+			want := []pushedStream{
+				{labels: `{app="api", level="info"}`, lines: []string{"one\t{\"app\": \"api\"}", "four\t{\"app\": \"api\"}"}},
+				{labels: `{app="web \"ui\"", level="info"}`, lines: []string{"two\t{\"app\": \"web \\\"ui\\\"\"}"}},
+				{labels: `{app="api", level="warn"}`, lines: []string{"three\t{\"app\": \"api\"}"}},
+			}
+			got := pushed[0].streams
+			if len(got) != len(want) {
+				t.Fatalf("got %d streams, want %d: %+v", len(got), len(want), got)
+			}
+			for i := range want {
+				if got[i].labels != want[i].labels || fmt.Sprint(got[i].lines) != fmt.Sprint(want[i].lines) {
+					t.Errorf("stream %d: got %s %q, want %s %q", i, got[i].labels, got[i].lines, want[i].labels, want[i].lines)
+				}
+				for _, ts := range got[i].ts {
+					if ts != testTime.UnixNano() {
+						t.Errorf("stream %d: got timestamp %d, want %d", i, ts, testTime.UnixNano())
+					}
+				}
+			}
+
+			h := pushed[0].header
+			if got := h.Get("X-Scope-OrgID"); got != "tenant" {
+				t.Errorf("got tenant %q", got)
+			}
+			if got := h.Get("Authorization"); got != "Bearer token" {
+				t.Errorf("got authorization %q", got)
+			}
+			wantType, wantEncoding := "application/x-protobuf", ""
+			if format == FormatJSON {
+				wantType, wantEncoding = "application/json", "gzip"
+			}
+			if h.Get("Content-Type") != wantType || h.Get("Content-Encoding") != wantEncoding {
+				t.Errorf("got content type %q and encoding %q", h.Get("Content-Type"), h.Get("Content-Encoding"))
+			}
+		})
+	}
+}
+
+func TestWriterJobLabel(t *testing.T) {
+	s := newStandIn(t)
+	w := newTestWriter(t, Configuration{URL: s.URL, Labels: []string{"app"}})
+	core := NewCore(lineEncoder(), w, zapcore.DebugLevel)
+	// The labels of namespaced fields are ignored.
+	write(t, core, zapcore.InfoLevel, "nested", zap.Namespace("req"), zap.String("app", "api"))
+	if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	pushed := s.pushed()
+	if len(pushed) != 1 || len(pushed[0].streams) != 1 {
+		t.Fatalf("got %+v", pushed)
+	}
+	if got, want := pushed[0].streams[0].labels, fmt.Sprintf("{job=%q}", defaultJob); got != want {
+		t.Errorf("got labels %s, want %s", got, want)
+	}
+}
+
+func TestWriterBatches(t *testing.T) {
+	s := newStandIn(t)
+	w := newTestWriter(t, Configuration{URL: s.URL, BatchSize: 2})
+	core := NewCore(lineEncoder(), w, zapcore.DebugLevel)
+	for i := 0; i < 5; i++ {
+		write(t, core, zapcore.InfoLevel, strconv.Itoa(i))
+	}
+	if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for _, req := range s.pushed() {
+		n := 0
+		for _, st := range req.streams {
+			n += len(st.lines)
+			lines = append(lines, st.lines...)
+		}
+		if n > 2 {
+			t.Errorf("pushed %d entries at once", n)
+		}
+	}
+	if fmt.Sprint(lines) != "[0 1 2 3 4]" {
+		t.Errorf("got %v", lines)
+	}
+}
+
+func TestWriterRetries(t *testing.T) {
+	for _, status := range []int{http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusServiceUnavailable} {
+		t.Run(strconv.Itoa(status), func(t *testing.T) {
+			s := newStandIn(t)
+			s.statuses = []int{status, status}
+			w := newTestWriter(t, Configuration{URL: s.URL, BatchSize: 3, BatchWait: 10 * time.Millisecond})
+			core := NewCore(lineEncoder(), w, zapcore.DebugLevel)
+			for i := 0; i < 3; i++ {
+				write(t, core, zapcore.InfoLevel, strconv.Itoa(i))
+			}
+			waitFor(t, "the entries to be sent", func() bool { return w.Stats().Sent == 3 })
+			if got := w.Stats(); got.Failures != 2 || got.Dropped != 0 || got.Queued != 0 {
+				t.Errorf("got %+v", got)
+			}
+			pushed := s.pushed()
+			if len(pushed) != 1 || fmt.Sprint(pushed[0].streams[0].lines) != "[0 1 2]" {
+				t.Errorf("got %+v", pushed)
+			}
+		})
+	}
+}
+
+func TestWriterMaxRetries(t *testing.T) {
+	s := newStandIn(t)
+	s.failing = http.StatusBadGateway
+	w := newTestWriter(t, Configuration{URL: s.URL, MaxRetries: 2, BatchWait: 10 * time.Millisecond})
+	core := NewCore(lineEncoder(), w, zapcore.DebugLevel)
+	write(t, core, zapcore.InfoLevel, "lost")
+	waitFor(t, "the entry to be dropped", func() bool { return w.Stats().Dropped == 1 })
+	if got := w.Stats(); got.Failures != 3 || got.Queued != 0 {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestWriterRejected(t *testing.T) {
+	s := newStandIn(t)
+	s.failing = http.StatusBadRequest
+	w := newTestWriter(t, Configuration{URL: s.URL})
+	core := NewCore(lineEncoder(), w, zapcore.DebugLevel)
+	write(t, core, zapcore.InfoLevel, "rejected")
+	if err := w.Sync(); err == nil {
+		t.Error("no error from Sync")
+	}
+	if got := w.Stats(); got.Dropped != 1 || got.Failures != 1 || got.Queued != 0 {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestWriterSyncDoesNotWaitForRetries(t *testing.T) {
+	s := newStandIn(t)
+	s.failing = http.StatusServiceUnavailable
+	w := newTestWriter(t, Configuration{
+		URL:        s.URL,
+		BatchWait:  10 * time.Millisecond,
+		MinBackoff: time.Minute,
+		MaxBackoff: time.Minute,
+		MaxRetries: 1,
+	})
+	core := NewCore(lineEncoder(), w, zapcore.DebugLevel)
+	write(t, core, zapcore.InfoLevel, "first")
+	waitFor(t, "a failure", func() bool { return w.Stats().Failures == 1 })
+
+	// Panic entries are queued behind the batch waiting to be retried, and
+	// Write returns its error.
+	start := time.Now()
+	if err := core.Write(zapcore.Entry{Level: zapcore.PanicLevel, Time: testTime, Message: "panic"}, nil); err == nil {
+		t.Error("no error from Write")
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("Write took %v", d)
+	}
+	// Syncs don't use up the retries.
+	for i := 0; i < 3; i++ {
+		if err := w.Sync(); err == nil {
+			t.Error("no error from Sync")
+		}
+	}
+	if got := w.Stats(); got.Failures != 1 || got.Queued != 2 || got.Dropped != 0 {
+		t.Errorf("got %+v", got)
+	}
+
+	// Loki is back: the entries are sent in order on Close.
+	s.mu.Lock()
+	s.failing = 0
+	s.mu.Unlock()
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	pushed := s.pushed()
+	if len(pushed) != 1 || fmt.Sprint(pushed[0].streams[0].lines) != "[first panic]" {
+		t.Errorf("got %+v", pushed)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	tests := []struct {
+		value    string
+		min, max time.Duration
+	}{
+		{"", 0, 0},
+		{"120", 2 * time.Minute, 2 * time.Minute},
+		{time.Now().Add(time.Hour).UTC().Format(http.TimeFormat), 59 * time.Minute, time.Hour},
+		{"soon", 0, 0},
+	}
+	for _, tt := range tests {
+		if got := retryAfter(tt.value); got < tt.min || got > tt.max {
+			t.Errorf("retryAfter(%q) = %v, want between %v and %v", tt.value, got, tt.min, tt.max)
+		}
+	}
+}
+
+func TestLabelSetString(t *testing.T) {
+	ls := labelSet{{name: "a", value: "x\"y\\z\nw"}, {name: "b", value: "\x01\xffé\t"}}
+	want := "{a=\"x\\\"y\\\\z\\nw\", b=\"\x01\xffé\t\"}"
+	if got := ls.String(); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestSetLabel(t *testing.T) {
+	base := labelSet{{name: "b", value: "1"}}
+	got := setLabel(base, "a", "2", false)
+	got = setLabel(got, "c", "3", false)
+	got = setLabel(got, "b", "kept", false)
+	if got.String() != `{a="2", b="1", c="3"}` {
+		t.Errorf("got %s", got)
+	}
+	if got = setLabel(got, "b", "replaced", true); got.String() != `{a="2", b="replaced", c="3"}` {
+		t.Errorf("got %s", got)
+	}
+	if base.String() != `{b="1"}` {
+		t.Errorf("base changed to %s", base)
+	}
+	names := []string{"app.name", "1st", "ok_9", ""}
+	var out []string
+	for _, n := range names {
+		out = append(out, labelName(n))
+	}
+	sort.Strings(out)
+	if fmt.Sprint(out) != "[_ _1st app_name ok_9]" {
+		t.Errorf("got %v", out)
+	}
+}
@@ -0,0 +1,143 @@
+package log
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// Named creates a child of the global logger for a module. Its level can be
+// configured apart from the root level with the logging.levels key or the
+// LevelHandler. Levels are resolved from the most specific name: the level
+// of "payments.stripe" falls back to the one of "payments", then to the root
+// level.
+//
+// Viper lowers the case of configuration keys, so module names should be
+// lower case to be configurable.
+func Named(name string) *zap.Logger {
+	return global().l.Named(name)
+}
+
+// moduleLevels is the root level and the levels of the named loggers. It is
+// copied on write so that the entries are checked without locking.
+type moduleLevels struct {
+	mu      sync.Mutex // serializes writers
+	current atomic.Value
+}
+
+type levelTable struct {
+	root    zapcore.Level
+	modules map[string]zapcore.Level
+	// min is the lowest of all levels, used to skip disabled entries before
+	// their logger name is known.
+	min zapcore.Level
+}
+
+func newModuleLevels(root zapcore.Level, modules map[string]zapcore.Level) *moduleLevels {
+	m := &moduleLevels{}
+	m.store(root, modules)
+	return m
+}
+
+func (m *moduleLevels) load() *levelTable {
+	return m.current.Load().(*levelTable)
+}
+
+func (m *moduleLevels) store(root zapcore.Level, modules map[string]zapcore.Level) {
+	t := &levelTable{root: root, modules: modules, min: root}
+	for _, lvl := range modules {
+		if lvl < t.min {
+			t.min = lvl
+		}
+	}
+	m.current.Store(t)
+}
+
+// Enabled reports whether any logger is enabled at lvl.
+func (m *moduleLevels) Enabled(lvl zapcore.Level) bool {
+	return lvl >= m.load().min
+}
+
+// enabledFor reports whether the logger with the given name is enabled at
+// lvl.
+func (m *moduleLevels) enabledFor(name string, lvl zapcore.Level) bool {
+	t := m.load()
+	if lvl < t.min {
+		return false
+	}
+	return lvl >= t.resolve(name)
+}
+
+func (t *levelTable) resolve(name string) zapcore.Level {
+	if len(t.modules) == 0 {
+		return t.root
+	}
+	for name != "" {
+		if lvl, ok := t.modules[name]; ok {
+			return lvl
+		}
+		idx := strings.LastIndexByte(name, '.')
+		if idx < 0 {
+			break
+		}
+		name = name[:idx]
+	}
+	return t.root
+}
+
+// get returns the level configured for name, the root level if name is
+// empty.
+func (m *moduleLevels) get(name string) (zapcore.Level, bool) {
+	t := m.load()
+	if name == "" {
+		return t.root, true
+	}
+	lvl, ok := t.modules[name]
+	return lvl, ok
+}
+
+// set changes the level of name, the root level if name is empty. A nil level
+// removes the level of the module, which then falls back to its parent.
+func (m *moduleLevels) set(name string, lvl *zapcore.Level) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t := m.load()
+	if name == "" {
+		if lvl != nil {
+			m.store(*lvl, t.modules)
+		}
+		return
+	}
+	modules := make(map[string]zapcore.Level, len(t.modules)+1)
+	for k, v := range t.modules {
+		modules[k] = v
+	}
+	if lvl != nil {
+		modules[name] = *lvl
+	} else {
+		delete(modules, name)
+	}
+	m.store(t.root, modules)
+}
+
+// replace sets all the levels at once.
+func (m *moduleLevels) replace(root zapcore.Level, modules map[string]zapcore.Level) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.store(root, modules)
+}
+
+// names returns the names of the modules with a level, sorted.
+func (m *moduleLevels) names() []string {
+	t := m.load()
+	names := make([]string, 0, len(t.modules))
+	for name := range t.modules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,50 @@
+package log
+
+import (
+	"testing"
+
+	"go.uber.org/zap/zapcore"
+)
+
+func TestNamedLevels(t *testing.T) {
+	initTestLog(t, &Config{
+		Level: "info",
+		Levels: map[string]string{
+			"payments":        "debug",
+			"payments.stripe": "warn",
+		},
+		Outputs: []OutputConfig{{Type: outputStderr}},
+	})
+
+	tests := []struct {
+		name    string
+		lvl     zapcore.Level
+		enabled bool
+	}{
+		{"", zapcore.DebugLevel, false},
+		{"", zapcore.InfoLevel, true},
+		{"other", zapcore.DebugLevel, false},
+		{"payments", zapcore.DebugLevel, true},
+		{"payments.paypal", zapcore.DebugLevel, true},
+		{"payments.stripe", zapcore.InfoLevel, false},
+		{"payments.stripe", zapcore.WarnLevel, true},
+		{"payments.stripe.webhooks", zapcore.InfoLevel, false},
+		{"payments.stripe.webhooks", zapcore.WarnLevel, true},
+		// Not a child of "payments".
+		{"paymentsx", zapcore.DebugLevel, false},
+	}
+	for _, tt := range tests {
+		logger := global().l
+		if tt.name != "" {
+			logger = Named(tt.name)
+		}
+		if enabled := logger.Check(tt.lvl, "msg") != nil; enabled != tt.enabled {
+			t.Errorf("%q at %v: enabled is %v, want %v", tt.name, tt.lvl, enabled, tt.enabled)
+		}
+	}
+
+	// Named loggers of named loggers join their names with dots.
+	if ce := Named("payments").Named("stripe").Check(zapcore.InfoLevel, "msg"); ce != nil {
+		t.Error("info entries of payments.stripe are enabled")
+	}
+}
@@ -0,0 +1,323 @@
+package log
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/fsnotify/fsnotify"
+	logasync "github.com/liasece/log/async"
+	logsentry "github.com/liasece/log/sentry"
+	logship "github.com/liasece/log/ship"
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// logState is everything InitLog builds that a reload may change.
+type logState struct {
+	mu         sync.Mutex // serializes reloads
+	fileName   string
+	cfg        *Config
+	levels     *moduleLevels
+	stacktrace zap.AtomicLevel
+	overrides  levelOverrides
+	core       *swapCore
+}
+
+func newLogState(cfg *Config, fileName string) (*logState, error) {
+	root, modules, stacktrace := cfg.levels()
+	s := &logState{
+		fileName:   fileName,
+		cfg:        cfg,
+		levels:     newModuleLevels(root, modules),
+		stacktrace: zap.NewAtomicLevelAt(stacktrace),
+	}
+	core, closers, err := cfg.buildCore(fileName, nil)
+	if err != nil {
+		return nil, err
+	}
+	s.core = newSwapCore(core, closers, s.levels)
+	return s, nil
+}
+
+func (s *logState) logger() *zap.Logger {
+	opts := []zap.Option{zap.AddStacktrace(s.stacktrace)}
+	if s.cfg.Caller == nil || *s.cfg.Caller {
+		opts = append(opts, zap.AddCaller())
+	}
+	return zap.New(s.core, opts...)
+}
+
+// reload rebuilds the outputs of cfg and swaps them in. Nothing changes if
+// cfg is invalid or an output can't be opened.
+func (s *logState) reload(cfg *Config) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if reflect.DeepEqual(cfg, s.cfg) {
+		return nil
+	}
+	core, closers, err := cfg.buildCore(s.fileName, s.core.closers)
+	if err != nil {
+		return err
+	}
+	root, modules, stacktrace := cfg.levels()
+	err = s.core.swap(core, closers, func() error {
+		if err := takeOverShip(closers); err != nil {
+			return err
+		}
+		s.overrides.cancel()
+		s.levels.replace(root, modules)
+		s.stacktrace.SetLevel(stacktrace)
+		cfg.applyTraceFields()
+		return nil
+	})
+	if err != nil {
+		closeAll(unused(closers, s.core.closers))
+		return err
+	}
+	s.cfg = cfg
+	return nil
+}
+
+// ReloadLog applies the "logging" key of cfg to the logger built by InitLog:
+// the levels, encoders, outputs, sampling, fields, trace field names and
+// sentry settings are rebuilt and swapped in at once. Loggers derived from the global logger
+// follow the change. Whether the caller is added can't be changed without
+// calling InitLog again.
+//
+// The running configuration is kept if cfg is invalid.
+func ReloadLog(cfg *viper.Viper) error {
+	s := global().state
+	if s == nil {
+		return fmt.Errorf("logger was not initialized by InitLog")
+	}
+	c, err := LoadConfig(cfg)
+	if err != nil {
+		return err
+	}
+	return s.reload(c)
+}
+
+// WatchConfig watches the configuration file of cfg and reloads the logger
+// when it changes. It replaces the handler registered by cfg.OnConfigChange;
+// services with their own handler should call ReloadLog from it instead.
+func WatchConfig(cfg *viper.Viper) {
+	cfg.OnConfigChange(func(fsnotify.Event) {
+		if err := ReloadLog(cfg); err != nil {
+			Error("reload logging configuration failed", ErrorField(err))
+		}
+	})
+	cfg.WatchConfig()
+}
+
+// swapCore is a zapcore.Core whose core tree can be replaced while it is in
+// use. Every entry is written to exactly one tree: writes hold a read lock
+// and a swap waits for them before the old outputs are synced and closed.
+//
+// The root and module levels are checked here, before the entry gets to the
+// tree, so that entries of disabled modules are dropped before zap gathers
+// their caller and stack trace.
+type swapCore struct {
+	*swapRoot
+	fields []zapcore.Field
+	cache  atomic.Value // *swapDerived
+}
+
+type swapRoot struct {
+	levels  *moduleLevels
+	mu      sync.RWMutex
+	current atomic.Value // *swapGeneration
+	closers []io.Closer
+}
+
+type swapGeneration struct {
+	core zapcore.Core
+}
+
+// swapDerived is the core of a generation with the fields of a swapCore.
+type swapDerived struct {
+	gen  *swapGeneration
+	core zapcore.Core
+}
+
+func newSwapCore(core zapcore.Core, closers []io.Closer, levels *moduleLevels) *swapCore {
+	root := &swapRoot{levels: levels, closers: closers}
+	root.current.Store(&swapGeneration{core: core})
+	return &swapCore{swapRoot: root}
+}
+
+// swap replaces the core tree. apply runs while no entry is being written;
+// the running tree is kept if it fails.
+func (r *swapRoot) swap(core zapcore.Core, closers []io.Closer, apply func() error) error {
+	r.mu.Lock()
+	if apply != nil {
+		if err := apply(); err != nil {
+			r.mu.Unlock()
+			return err
+		}
+	}
+	old := r.current.Load().(*swapGeneration)
+	oldClosers := r.closers
+	r.current.Store(&swapGeneration{core: core})
+	r.closers = closers
+	r.mu.Unlock()
+
+	_ = old.core.Sync()
+	closeAll(unused(oldClosers, closers))
+	return nil
+}
+
+func (c *swapCore) load() zapcore.Core {
+	gen := c.current.Load().(*swapGeneration)
+	if len(c.fields) == 0 {
+		return gen.core
+	}
+	if d, ok := c.cache.Load().(*swapDerived); ok && d.gen == gen {
+		return d.core
+	}
+	d := &swapDerived{gen: gen, core: gen.core.With(c.fields)}
+	c.cache.Store(d)
+	return d.core
+}
+
+func (c *swapCore) Enabled(lvl zapcore.Level) bool {
+	return c.levels.Enabled(lvl) && c.load().Enabled(lvl)
+}
+
+func (c *swapCore) With(fields []zapcore.Field) zapcore.Core {
+	fs := make([]zapcore.Field, 0, len(c.fields)+len(fields))
+	fs = append(fs, c.fields...)
+	fs = append(fs, fields...)
+	return &swapCore{swapRoot: c.swapRoot, fields: fs}
+}
+
+func (c *swapCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.levels.enabledFor(ent.LoggerName, ent.Level) && c.load().Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+// Write checks the entry again against the current tree, which may differ
+// from the one it was checked against, so that samplers and per-output
+// levels apply.
+func (c *swapCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if ce := c.load().Check(ent, nil); ce != nil {
+		ce.ErrorOutput = _errorOutput
+		ce.Write(fields...)
+	}
+	return nil
+}
+
+func (c *swapCore) Sync() error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.load().Sync()
+}
+
+// AsyncStats returns the sum of the counters of the outputs written from a
+// goroutine, see the logging.async key.
+func AsyncStats() logasync.Stats {
+	var stats logasync.Stats
+	s := global().state
+	if s == nil {
+		return stats
+	}
+	s.core.mu.RLock()
+	defer s.core.mu.RUnlock()
+	for _, c := range s.core.closers {
+		if w, ok := c.(*logasync.Writer); ok {
+			ws := w.Stats()
+			stats.Buffered += ws.Buffered
+			stats.Written += ws.Written
+			stats.Dropped += ws.Dropped
+		}
+	}
+	return stats
+}
+
+// ShipStats returns the sum of the counters of the ship outputs, see
+// logship.Stats.
+func ShipStats() logship.Stats {
+	var stats logship.Stats
+	s := global().state
+	if s == nil {
+		return stats
+	}
+	s.core.mu.RLock()
+	defer s.core.mu.RUnlock()
+	for _, c := range s.core.closers {
+		if o, ok := c.(*shipOutput); ok {
+			w := o.load()
+			if w == nil {
+				continue
+			}
+			ws := w.Stats()
+			stats.Queued += ws.Queued
+			stats.Spilled += ws.Spilled
+			stats.SpilledBytes += ws.SpilledBytes
+			stats.Sent += ws.Sent
+			stats.Failures += ws.Failures
+			stats.Dropped += ws.Dropped
+		}
+	}
+	return stats
+}
+
+// SentryStats returns the counters of the events of the sentry output, see
+// the logging.sentry key and logsentry.Stats.
+func SentryStats() logsentry.Stats {
+	var stats logsentry.Stats
+	s := global().state
+	if s == nil {
+		return stats
+	}
+	s.core.mu.RLock()
+	defer s.core.mu.RUnlock()
+	for _, c := range s.core.closers {
+		if g, ok := c.(logsentry.StatsGetter); ok {
+			gs := g.Stats()
+			stats.Sent += gs.Sent
+			stats.RateLimited += gs.RateLimited
+			stats.Duplicates += gs.Duplicates
+		}
+	}
+	return stats
+}
+
+var _errorOutput = zapcore.Lock(os.Stderr)
+
+// unused returns the closers of old that are not in current, the outputs
+// that a reload didn't reuse.
+func unused(old, current []io.Closer) []io.Closer {
+	var closers []io.Closer
+next:
+	for _, c := range old {
+		for _, k := range current {
+			if c == k {
+				continue next
+			}
+		}
+		closers = append(closers, c)
+	}
+	return closers
+}
+
+func closeAll(closers []io.Closer) {
+	var errs []string
+	for _, c := range closers {
+		if err := c.Close(); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		_, _ = fmt.Fprintf(os.Stderr, "failed to close log outputs [%s]\n", strings.Join(errs, "; "))
+	}
+}
@@ -0,0 +1,335 @@
+package log
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap/zapcore"
+)
+
+// collector is a stand-in ship collector that keeps the messages it receives.
+type collector struct {
+	*httptest.Server
+	mu   sync.Mutex
+	msgs []string
+}
+
+func newCollector(t *testing.T) *collector {
+	t.Helper()
+	c := &collector{}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		for _, line := range strings.Split(strings.TrimSpace(string(body)), "\n") {
+			var e struct {
+				Msg string `json:"msg"`
+			}
+			if err := json.Unmarshal([]byte(line), &e); err != nil {
+				t.Errorf("invalid entry %q: %v", line, err)
+				continue
+			}
+			c.msgs = append(c.msgs, e.Msg)
+		}
+	}))
+	t.Cleanup(c.Close)
+	return c
+}
+
+func (c *collector) received() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.msgs...)
+}
+
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "log")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	return dir
+}
+
+// initTestLog initializes the global logger with cfg and restores the
+// previous one at the end of the test.
+func initTestLog(t *testing.T, cfg *Config) *logState {
+	t.Helper()
+	prev := global()
+	if err := initZapLogger(cfg, ""); err != nil {
+		t.Fatal(err)
+	}
+	s := global().state
+	t.Cleanup(func() {
+		replaceGlobal(prev)
+		closeAll(s.core.closers)
+		SetTraceFieldNames(DefaultTraceFieldNames)
+	})
+	return s
+}
+
+func shipConfig(url, dir string, batchSize int) *Config {
+	return &Config{Outputs: []OutputConfig{{
+		Type: outputShip,
+		Ship: ShipConfig{URL: url, Dir: dir, BatchSize: batchSize, BatchInterval: 10 * time.Millisecond},
+	}}}
+}
+
+func logMessages(from, to int) {
+	for i := from; i < to; i++ {
+		Info(fmt.Sprintf("entry %03d", i))
+	}
+}
+
+func checkMessages(t *testing.T, got []string, to int) {
+	t.Helper()
+	if len(got) != to {
+		t.Fatalf("got %d entries, want %d: %q", len(got), to, got)
+	}
+	for i, msg := range got {
+		if want := fmt.Sprintf("entry %03d", i); msg != want {
+			t.Fatalf("entry %d is %q, want %q", i, msg, want)
+		}
+	}
+}
+
+func TestReloadHandsShipDirOver(t *testing.T) {
+	c := newCollector(t)
+	dir := tempDir(t)
+	s := initTestLog(t, shipConfig(c.URL, dir, 5))
+
+	logMessages(0, 10)
+	if err := s.reload(shipConfig(c.URL, dir, 3)); err != nil {
+		t.Fatal(err)
+	}
+	logMessages(10, 20)
+	if err := Sync(); err != nil {
+		t.Fatal(err)
+	}
+	checkMessages(t, c.received(), 20)
+	if stats := ShipStats(); stats.Dropped != 0 {
+		t.Errorf("dropped %d entries", stats.Dropped)
+	}
+}
+
+func TestReloadKeepsShipWriterOnFailure(t *testing.T) {
+	c := newCollector(t)
+	dir := tempDir(t)
+	running := shipConfig(c.URL, dir, 5)
+	s := initTestLog(t, running)
+
+	// The file can't be created under a regular file, after the ship output
+	// of the same directory was built.
+	notDir := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(notDir, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg := shipConfig(c.URL, dir, 3)
+	cfg.Outputs = append(cfg.Outputs, OutputConfig{Type: outputFile, Path: filepath.Join(notDir, "app.log")})
+
+	logMessages(0, 10)
+	if err := s.reload(cfg); err == nil {
+		t.Fatal("reload succeeded")
+	}
+	if s.cfg != running {
+		t.Error("running configuration was replaced")
+	}
+	logMessages(10, 20)
+	if err := Sync(); err != nil {
+		t.Fatal(err)
+	}
+	checkMessages(t, c.received(), 20)
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func fileConfig(path string) *Config {
+	return &Config{Outputs: []OutputConfig{{Type: outputFile, Path: path, Encoding: encodingJSON}}}
+}
+
+// readMessages returns the messages of the JSON entries of a file.
+func readMessages(t *testing.T, path string) []string {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var msgs []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e struct {
+			Msg string `json:"msg"`
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("invalid entry %q: %v", scanner.Text(), err)
+		}
+		msgs = append(msgs, e.Msg)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return msgs
+}
+
+func TestReloadSwapsOutputs(t *testing.T) {
+	dir := tempDir(t)
+	before, after := filepath.Join(dir, "before.log"), filepath.Join(dir, "after.log")
+	s := initTestLog(t, fileConfig(before))
+
+	const writers, entries = 4, 500
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < entries; j++ {
+				Info(fmt.Sprintf("entry %d-%d", i, j))
+			}
+		}(i)
+	}
+	if err := s.reload(fileConfig(after)); err != nil {
+		t.Fatal(err)
+	}
+	wg.Wait()
+	if err := Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every entry is written once, to either file.
+	seen := make(map[string]bool)
+	for _, path := range []string{before, after} {
+		for _, msg := range readMessages(t, path) {
+			if seen[msg] {
+				t.Fatalf("%q written twice", msg)
+			}
+			seen[msg] = true
+		}
+	}
+	if len(seen) != writers*entries {
+		t.Errorf("wrote %d entries, want %d", len(seen), writers*entries)
+	}
+}
+
+func TestReloadLogKeepsRunningConfigWhenInvalid(t *testing.T) {
+	path := filepath.Join(tempDir(t), "app.log")
+	running := fileConfig(path)
+	running.Level = "warn"
+	s := initTestLog(t, running)
+
+	v := viper.New()
+	v.Set("logging.level", "loud")
+	if err := ReloadLog(v); err == nil {
+		t.Fatal("reload succeeded")
+	}
+	v = viper.New()
+	v.Set("logging.levle", "debug")
+	if err := ReloadLog(v); err == nil {
+		t.Fatal("reload with an unknown key succeeded")
+	}
+	if s.cfg != running {
+		t.Error("running configuration was replaced")
+	}
+
+	Info("dropped")
+	Warn("kept")
+	if got := readMessages(t, path); len(got) != 1 || got[0] != "kept" {
+		t.Errorf("wrote %q", got)
+	}
+}
+
+func TestReloadCancelsOverrides(t *testing.T) {
+	s := initTestLog(t, &Config{Outputs: []OutputConfig{{Type: outputStderr}}})
+	debug := zapcore.DebugLevel
+	s.overrides.set(s.levels, "", &debug, time.Hour)
+	s.overrides.set(s.levels, "payments", &debug, time.Hour)
+
+	cfg := &Config{Level: "error", Outputs: []OutputConfig{{Type: outputStderr}}}
+	if err := s.reload(cfg); err != nil {
+		t.Fatal(err)
+	}
+	resp := s.overrides.snapshot(s.levels)
+	if resp.Level != "error" || resp.Expires != nil || len(resp.Levels) != 0 {
+		t.Errorf("levels are %+v after reload", resp)
+	}
+}
+
+func TestReloadResetsTraceFields(t *testing.T) {
+	cfg := &Config{TraceFields: traceFieldsOTel, Outputs: []OutputConfig{{Type: outputStderr}}}
+	s := initTestLog(t, cfg)
+	if got := traceFieldNames(); got != OTelTraceFieldNames {
+		t.Fatalf("trace field names are %+v after InitLog", got)
+	}
+	if err := s.reload(&Config{Outputs: []OutputConfig{{Type: outputStderr}}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := traceFieldNames(); got != DefaultTraceFieldNames {
+		t.Errorf("trace field names are %+v after reload", got)
+	}
+}
+
+func TestWatchConfig(t *testing.T) {
+	dir := tempDir(t)
+	logPath := filepath.Join(dir, "app.log")
+	cfgPath := filepath.Join(dir, "config.yaml")
+	// The file is replaced by a rename so that the watcher sees a single
+	// event.
+	writeConfig := func(level string) {
+		t.Helper()
+		data := fmt.Sprintf("logging:\n  level: %s\n  outputs:\n    - type: file\n      path: %s\n", level, logPath)
+		tmp := filepath.Join(dir, "config.tmp")
+		if err := ioutil.WriteFile(tmp, []byte(data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Rename(tmp, cfgPath); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig("warn")
+	v := viper.New()
+	v.SetConfigFile(cfgPath)
+	if err := v.ReadInConfig(); err != nil {
+		t.Fatal(err)
+	}
+	prev := global()
+	if err := InitLog("", v); err != nil {
+		t.Fatal(err)
+	}
+	s := global().state
+	t.Cleanup(func() {
+		// Removing the file stops the watcher.
+		_ = os.Remove(cfgPath)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		replaceGlobal(prev)
+		closeAll(s.core.closers)
+	})
+
+	WatchConfig(v)
+	writeConfig("debug")
+	waitFor(t, "the new level", func() bool {
+		lvl, _ := s.levels.get("")
+		return lvl == zapcore.DebugLevel
+	})
+}
@@ -0,0 +1,445 @@
+package logrotate
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	backupTimeFormat = "2006-01-02T15-04-05.000"
+	compressSuffix   = ".gz"
+	megabyte         = 1024 * 1024
+	// rotateRetryDelay is how long a failed rotation waits to be retried.
+	rotateRetryDelay = time.Minute
+)
+
+// rename is replaced by the tests.
+var rename = os.Rename
+
+var errClosed = errors.New("logrotate: writer closed")
+
+// Configuration is the set of parameters for a rotating log file.
+type Configuration struct {
+	// Filename is the file to write logs to. Backups are kept in the same
+	// directory.
+	Filename string
+	// MaxSize is the maximum size in megabytes of the log file before it gets
+	// rotated. Zero disables size based rotation.
+	MaxSize int
+	// RotationTime is the maximum age of the log file before it gets rotated.
+	// Zero disables age based rotation. The age of a file that exists when
+	// the writer opens it counts from its last modification, as its creation
+	// time is not available on every system, so it may rotate late after a
+	// restart.
+	RotationTime time.Duration
+	// MaxAge is the maximum time to retain rotated files. Zero keeps them
+	// regardless of their age.
+	MaxAge time.Duration
+	// MaxBackups is the maximum number of rotated files to retain. Zero keeps
+	// all of them.
+	MaxBackups int
+	// Compress determines if the rotated files should be gzip compressed.
+	Compress bool
+	// LocalTime makes backup file names use the local time instead of UTC.
+	LocalTime bool
+}
+
+// Writer is a zapcore.WriteSyncer that writes to a file and rotates it when it
+// becomes too large or too old.
+type Writer struct {
+	cfg Configuration
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openTime time.Time
+	closed   bool
+
+	// rotateErr is the last rotation failure, retried from retryRotate.
+	rotateErr   error
+	retryRotate time.Time
+
+	millCh   chan struct{} // nil until the first rotation
+	millDone chan struct{}
+}
+
+// NewWriter opens (or creates) the configured file for appending.
+func NewWriter(cfg Configuration) (*Writer, error) {
+	if cfg.Filename == "" {
+		return nil, errors.New("logrotate: empty file name")
+	}
+	if cfg.MaxSize < 0 || cfg.MaxBackups < 0 || cfg.MaxAge < 0 || cfg.RotationTime < 0 {
+		return nil, errors.New("logrotate: negative limits are not allowed")
+	}
+	w := &Writer{cfg: cfg}
+	if err := w.openExistingOrNew(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Write implements io.Writer. It rotates the file before writing if the write
+// would exceed MaxSize or the file is older than RotationTime.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.closed {
+		return 0, errClosed
+	}
+	if w.file == nil {
+		if err := w.openExistingOrNew(); err != nil {
+			return 0, err
+		}
+	}
+	var rotateErr error
+	if w.shouldRotate(int64(len(p))) {
+		if err := w.rotate(); err != nil {
+			if w.file == nil {
+				return 0, err
+			}
+			// The entry is written to the current file, and the failure
+			// reported once until a rotation succeeds.
+			if w.rotateErr == nil {
+				rotateErr = err
+			}
+			w.rotateErr = err
+			w.retryRotate = time.Now().Add(rotateRetryDelay)
+		} else {
+			w.rotateErr = nil
+		}
+	}
+
+	n, err := w.file.Write(p)
+	w.size += int64(n)
+	if err == nil {
+		err = rotateErr
+	}
+	return n, err
+}
+
+// Sync commits the current contents of the file to stable storage.
+func (w *Writer) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	return w.file.Sync()
+}
+
+// Close closes the current file, and waits for the compression and removal
+// of old backups in progress. Writes and rotations fail after Close.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.closed = true
+	if w.millCh != nil {
+		close(w.millCh)
+		<-w.millDone
+		w.millCh, w.millDone = nil, nil
+	}
+	return w.close()
+}
+
+// Rotate forces a rotation: the current file is moved aside and a new one is
+// created in its place.
+func (w *Writer) Rotate() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return errClosed
+	}
+	return w.rotate()
+}
+
+func (w *Writer) shouldRotate(n int64) bool {
+	if w.rotateErr != nil && time.Now().Before(w.retryRotate) {
+		return false
+	}
+	if w.cfg.MaxSize > 0 && w.size > 0 && w.size+n > int64(w.cfg.MaxSize)*megabyte {
+		return true
+	}
+	if w.cfg.RotationTime > 0 && w.size > 0 && time.Since(w.openTime) >= w.cfg.RotationTime {
+		return true
+	}
+	return false
+}
+
+func (w *Writer) close() error {
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+func (w *Writer) openExistingOrNew() error {
+	if err := os.MkdirAll(filepath.Dir(w.cfg.Filename), 0755); err != nil {
+		return fmt.Errorf("logrotate: can't make directories for new logfile: %w", err)
+	}
+	f, err := os.OpenFile(w.cfg.Filename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("logrotate: can't open logfile: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return fmt.Errorf("logrotate: can't stat logfile: %w", err)
+	}
+	w.file = f
+	w.size = info.Size()
+	w.openTime = time.Now()
+	if info.Size() > 0 {
+		// The creation time is not portable, the last modification is the
+		// best approximation we have, see RotationTime.
+		w.openTime = info.ModTime()
+	}
+	return nil
+}
+
+// rotate renames the current file to a timestamped backup and opens a fresh
+// file. If the file can't be renamed, it is opened again so that the entries
+// are still written to it.
+func (w *Writer) rotate() error {
+	if w.file != nil {
+		if err := w.file.Sync(); err != nil {
+			return fmt.Errorf("logrotate: can't sync logfile: %w", err)
+		}
+		if err := w.close(); err != nil {
+			return fmt.Errorf("logrotate: can't close logfile: %w", err)
+		}
+	}
+	if _, err := os.Stat(w.cfg.Filename); err == nil {
+		if err := rename(w.cfg.Filename, w.backupName(time.Now())); err != nil {
+			if openErr := w.openExistingOrNew(); openErr != nil {
+				return openErr
+			}
+			return fmt.Errorf("logrotate: can't rename logfile: %w", err)
+		}
+	}
+	if err := w.openExistingOrNew(); err != nil {
+		return err
+	}
+	w.mill()
+	return nil
+}
+
+// backupName returns an unused name for a backup made at t. The rotations
+// within the same millisecond are told apart by a counter after the time.
+func (w *Writer) backupName(t time.Time) string {
+	dir := filepath.Dir(w.cfg.Filename)
+	prefix, ext := w.prefixAndExt()
+	if !w.cfg.LocalTime {
+		t = t.UTC()
+	}
+	stamp := t.Format(backupTimeFormat)
+	for n := 1; ; n++ {
+		name := filepath.Join(dir, prefix+stamp+ext)
+		if !exists(name) && !exists(name+compressSuffix) {
+			return name
+		}
+		stamp = t.Format(backupTimeFormat) + "." + strconv.Itoa(n)
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+func (w *Writer) prefixAndExt() (prefix, ext string) {
+	filename := filepath.Base(w.cfg.Filename)
+	ext = filepath.Ext(filename)
+	prefix = filename[:len(filename)-len(ext)] + "-"
+	return prefix, ext
+}
+
+// mill schedules compression and removal of old backups. Runs are coalesced
+// so that a burst of rotations only triggers one pass.
+func (w *Writer) mill() {
+	if w.cfg.MaxBackups == 0 && w.cfg.MaxAge == 0 && !w.cfg.Compress {
+		return
+	}
+	if w.millCh == nil {
+		w.millCh, w.millDone = make(chan struct{}, 1), make(chan struct{})
+		go w.millRun(w.millCh, w.millDone)
+	}
+	select {
+	case w.millCh <- struct{}{}:
+	default:
+	}
+}
+
+func (w *Writer) millRun(millCh <-chan struct{}, done chan<- struct{}) {
+	defer close(done)
+	for range millCh {
+		if err := w.millRunOnce(); err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "logrotate: %v\n", err)
+		}
+	}
+}
+
+type backupInfo struct {
+	timestamp time.Time
+	counter   int // of the backups made within the same millisecond
+	path      string
+}
+
+func (w *Writer) millRunOnce() error {
+	files, err := w.oldLogFiles()
+	if err != nil {
+		return err
+	}
+
+	var remove []backupInfo
+	if w.cfg.MaxBackups > 0 && len(files) > w.cfg.MaxBackups {
+		// A file and its compressed version count as one backup.
+		preserved := make(map[string]bool)
+		var keep []backupInfo
+		for _, f := range files {
+			name := strings.TrimSuffix(f.path, compressSuffix)
+			preserved[name] = true
+			if len(preserved) > w.cfg.MaxBackups {
+				remove = append(remove, f)
+			} else {
+				keep = append(keep, f)
+			}
+		}
+		files = keep
+	}
+	if w.cfg.MaxAge > 0 {
+		cutoff := time.Now().Add(-w.cfg.MaxAge)
+		var keep []backupInfo
+		for _, f := range files {
+			if f.timestamp.Before(cutoff) {
+				remove = append(remove, f)
+			} else {
+				keep = append(keep, f)
+			}
+		}
+		files = keep
+	}
+
+	var errs []string
+	for _, f := range remove {
+		if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err.Error())
+		}
+	}
+	if w.cfg.Compress {
+		for _, f := range files {
+			if strings.HasSuffix(f.path, compressSuffix) {
+				continue
+			}
+			if err := compressFile(f.path, f.path+compressSuffix); err != nil {
+				errs = append(errs, err.Error())
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+// oldLogFiles returns the backups of the current file, newest first.
+func (w *Writer) oldLogFiles() ([]backupInfo, error) {
+	dir := filepath.Dir(w.cfg.Filename)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("can't read log file directory: %w", err)
+	}
+	prefix, ext := w.prefixAndExt()
+	// The stamps are in the zone backupName formats them in.
+	loc := time.UTC
+	if w.cfg.LocalTime {
+		loc = time.Local
+	}
+
+	var files []backupInfo
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		name := e.Name()
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		stamp := strings.TrimPrefix(name, prefix)
+		switch {
+		case strings.HasSuffix(stamp, ext+compressSuffix):
+			stamp = strings.TrimSuffix(stamp, ext+compressSuffix)
+		case strings.HasSuffix(stamp, ext):
+			stamp = strings.TrimSuffix(stamp, ext)
+		default:
+			continue
+		}
+		counter := 0
+		if i := len(backupTimeFormat); len(stamp) > i+1 && stamp[i] == '.' {
+			n, err := strconv.Atoi(stamp[i+1:])
+			if err != nil {
+				continue
+			}
+			stamp, counter = stamp[:i], n
+		}
+		t, err := time.ParseInLocation(backupTimeFormat, stamp, loc)
+		if err != nil {
+			continue
+		}
+		files = append(files, backupInfo{timestamp: t, counter: counter, path: filepath.Join(dir, name)})
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].timestamp.Equal(files[j].timestamp) {
+			return files[i].counter > files[j].counter
+		}
+		return files[i].timestamp.After(files[j].timestamp)
+	})
+	return files, nil
+}
+
+func compressFile(src, dst string) (err error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat log file: %w", err)
+	}
+	gzf, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, info.Mode())
+	if err != nil {
+		return fmt.Errorf("failed to open compressed log file: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(dst)
+		}
+	}()
+
+	gz := gzip.NewWriter(gzf)
+	if _, err = io.Copy(gz, f); err != nil {
+		_ = gzf.Close()
+		return err
+	}
+	if err = gz.Close(); err != nil {
+		_ = gzf.Close()
+		return err
+	}
+	if err = gzf.Close(); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
@@ -0,0 +1,185 @@
+package logrotate
+
+import (
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func backups(t *testing.T, dir string) []string {
+	t.Helper()
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, info := range infos {
+		if info.Name() != "app.log" {
+			names = append(names, info.Name())
+		}
+	}
+	return names
+}
+
+func TestRotateUniqueNames(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriter(Configuration{Filename: filepath.Join(dir, "app.log")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	for i := 0; i < 5; i++ {
+		if _, err := w.Write([]byte("entry\n")); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Rotate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := backups(t, dir); len(got) != 5 {
+		t.Fatalf("backups = %v, want 5", got)
+	}
+	files, err := w.oldLogFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 5 {
+		t.Fatalf("oldLogFiles = %v, want 5", files)
+	}
+}
+
+func TestBackupNameCounter(t *testing.T) {
+	dir := t.TempDir()
+	w := &Writer{cfg: Configuration{Filename: filepath.Join(dir, "app.log")}}
+	now := time.Date(2020, 1, 2, 3, 4, 5, 6e6, time.UTC)
+	first := w.backupName(now)
+	if err := ioutil.WriteFile(first, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	second := w.backupName(now)
+	if err := ioutil.WriteFile(second+compressSuffix, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	third := w.backupName(now)
+	want := []string{
+		"app-2020-01-02T03-04-05.006.log",
+		"app-2020-01-02T03-04-05.006.1.log",
+		"app-2020-01-02T03-04-05.006.2.log",
+	}
+	for i, got := range []string{first, second, third} {
+		if filepath.Base(got) != want[i] {
+			t.Errorf("backupName #%d = %s, want %s", i, filepath.Base(got), want[i])
+		}
+	}
+	files, err := w.oldLogFiles()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || !strings.HasSuffix(files[0].path, ".1.log.gz") {
+		t.Fatalf("oldLogFiles = %v, want the counted backup first", files)
+	}
+}
+
+func TestRenameFailureKeepsWriting(t *testing.T) {
+	defer func(orig func(string, string) error) { rename = orig }(rename)
+	renameErr := errors.New("rename refused")
+	rename = func(string, string) error { return renameErr }
+
+	dir := t.TempDir()
+	name := filepath.Join(dir, "app.log")
+	w, err := NewWriter(Configuration{Filename: name, MaxSize: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	big := []byte(strings.Repeat("x", megabyte-1) + "\n")
+	if _, err := w.Write(big); err != nil {
+		t.Fatal(err)
+	}
+	n, err := w.Write([]byte("first\n"))
+	if !errors.Is(err, renameErr) || n != len("first\n") {
+		t.Fatalf("Write = %d, %v; want the entry written and the error", n, err)
+	}
+	if _, err := w.Write([]byte("second\n")); err != nil {
+		t.Fatalf("Write reported the failure again: %v", err)
+	}
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(data), "first\nsecond\n") {
+		t.Fatalf("entries lost, file ends with %q", data[len(data)-20:])
+	}
+}
+
+func TestCloseStopsMill(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewWriter(Configuration{Filename: filepath.Join(dir, "app.log"), MaxBackups: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		_, _ = w.Write([]byte("entry\n"))
+		if err := w.Rotate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	done := w.millDone
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	default:
+		t.Fatal("mill goroutine still running after Close")
+	}
+	if got := backups(t, dir); len(got) != 1 {
+		t.Fatalf("backups = %v, want 1", got)
+	}
+	if _, err := w.Write([]byte("entry\n")); err != errClosed {
+		t.Errorf("Write after Close = %v, want %v", err, errClosed)
+	}
+	if err := w.Rotate(); err != errClosed {
+		t.Errorf("Rotate after Close = %v, want %v", err, errClosed)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := backups(t, dir); len(got) != 1 {
+		t.Fatalf("backups = %v after Close, want 1", got)
+	}
+}
+
+func TestMaxAgeLocalTime(t *testing.T) {
+	defer func(orig *time.Location) { time.Local = orig }(time.Local)
+	// Ahead of UTC, so that local stamps read as UTC would be in the future.
+	time.Local = time.FixedZone("UTC+5", 5*60*60)
+
+	dir := t.TempDir()
+	w, err := NewWriter(Configuration{Filename: filepath.Join(dir, "app.log"), MaxAge: time.Hour, LocalTime: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	old, recent := w.backupName(now.Add(-2*time.Hour)), w.backupName(now.Add(-30*time.Minute))
+	for _, name := range []string{old, recent} {
+		if err := ioutil.WriteFile(name, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if exists(old) {
+		t.Errorf("%s was kept", filepath.Base(old))
+	}
+	if !exists(recent) {
+		t.Errorf("%s was removed", filepath.Base(recent))
+	}
+}
@@ -0,0 +1,80 @@
+package logsentry
+
+import (
+	"reflect"
+
+	"github.com/getsentry/sentry-go"
+	"go.uber.org/zap/zapcore"
+)
+
+// maxErrorDepth is the number of errors reported for a chain of wrapped
+// errors, as sentry does.
+const maxErrorDepth = 10
+
+var errorType = reflect.TypeOf((*error)(nil)).Elem()
+
+// fieldErrors returns the errors of the error fields of fs, and of the
+// arrays of errors such as those of zap.Errors.
+func fieldErrors(fs []zapcore.Field) []error {
+	var errs []error
+	for _, f := range fs {
+		switch f.Type {
+		case zapcore.ErrorType:
+			if err, ok := f.Interface.(error); ok && err != nil {
+				errs = append(errs, err)
+			}
+		case zapcore.ArrayMarshalerType:
+			v := reflect.ValueOf(f.Interface)
+			if v.Kind() != reflect.Slice || v.Type().Elem() != errorType {
+				continue
+			}
+			for i := 0; i < v.Len(); i++ {
+				if err, ok := v.Index(i).Interface().(error); ok && err != nil {
+					errs = append(errs, err)
+				}
+			}
+		}
+	}
+	return errs
+}
+
+// errorExceptions returns one exception per error of the chain of err, the
+// most recent last as sentry expects. The errors combined by multierr are
+// reported before the error that combined them, each with its own chain.
+func errorExceptions(err error, withStacktrace bool) []sentry.Exception {
+	var chain []sentry.Exception
+	var grouped []sentry.Exception
+	for i := 0; i < maxErrorDepth && err != nil; i++ {
+		if group, ok := err.(interface{ Errors() []error }); ok {
+			for _, e := range group.Errors() {
+				grouped = append(grouped, errorExceptions(e, withStacktrace)...)
+			}
+			break
+		}
+		exc := sentry.Exception{
+			Type:  reflect.TypeOf(err).String(),
+			Value: err.Error(),
+		}
+		if withStacktrace {
+			exc.Stacktrace = sentry.ExtractStacktrace(err)
+		}
+		chain = append(chain, exc)
+		err = unwrap(err)
+	}
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+	return append(grouped, chain...)
+}
+
+// unwrap returns the error wrapped by err, following errors.Unwrap and the
+// Cause method of github.com/pkg/errors.
+func unwrap(err error) error {
+	switch e := err.(type) {
+	case interface{ Unwrap() error }:
+		return e.Unwrap()
+	case interface{ Cause() error }:
+		return e.Cause()
+	}
+	return nil
+}
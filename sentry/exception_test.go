@@ -0,0 +1,98 @@
+package logsentry
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"go.uber.org/multierr"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// exceptionValues returns the values of the exceptions of the last event.
+func exceptionValues(t *testing.T, transport *fakeTransport) []string {
+	t.Helper()
+	var values []string
+	for _, exc := range transport.lastEvent(t).Exception {
+		values = append(values, exc.Value)
+	}
+	return values
+}
+
+func TestCoreErrorArrays(t *testing.T) {
+	core, transport := newTestCore(t, Configuration{Level: zapcore.ErrorLevel, DisableStacktrace: true})
+	writeEntry(t, core, zapcore.ErrorLevel,
+		zap.Errors("errors", []error{errors.New("first"), nil, errors.New("second")}),
+		zap.Strings("tags", []string{"not", "errors"}),
+		zap.Error(errors.New("third")))
+
+	got := exceptionValues(t, transport)
+	if len(got) != 3 || got[0] != "first" || got[1] != "second" || got[2] != "third" {
+		t.Errorf("exceptions are %q", got)
+	}
+}
+
+// causer wraps an error like github.com/pkg/errors does.
+type causer struct {
+	msg   string
+	cause error
+}
+
+func (c *causer) Error() string { return c.msg + ": " + c.cause.Error() }
+func (c *causer) Cause() error  { return c.cause }
+
+func TestCoreExceptionChain(t *testing.T) {
+	core, transport := newTestCore(t, Configuration{Level: zapcore.ErrorLevel})
+	base := errors.New("no rows")
+	err := &causer{msg: "load user", cause: fmt.Errorf("query: %w", base)}
+	zap.New(core, zap.AddCaller()).Error("failed", zap.Error(err))
+
+	exceptions := transport.lastEvent(t).Exception
+	var types []string
+	for _, exc := range exceptions {
+		types = append(types, exc.Type)
+	}
+	// The most recent error last.
+	want := []string{"*errors.errorString", "*fmt.wrapError", "*logsentry.causer"}
+	if !reflect.DeepEqual(types, want) {
+		t.Fatalf("exception types are %q, want %q", types, want)
+	}
+	if got := exceptionValues(t, transport); got[0] != "no rows" || got[2] != "load user: query: no rows" {
+		t.Errorf("exceptions are %q", got)
+	}
+	// Only the most recent error gets the stack of the caller.
+	if exceptions[0].Stacktrace != nil || exceptions[2].Stacktrace == nil {
+		t.Error("the stack trace is not on the most recent error")
+	}
+}
+
+func TestCoreExceptionMultierr(t *testing.T) {
+	core, transport := newTestCore(t, Configuration{Level: zapcore.ErrorLevel, DisableStacktrace: true})
+	err := fmt.Errorf("batch: %w", multierr.Combine(
+		errors.New("first"),
+		fmt.Errorf("second: %w", errors.New("cause")),
+	))
+	writeEntry(t, core, zapcore.ErrorLevel, zap.Error(err))
+
+	// Each combined error with its own chain, then the error combining them.
+	want := []string{"first", "cause", "second: cause", "batch: first; second: cause"}
+	if got := exceptionValues(t, transport); !reflect.DeepEqual(got, want) {
+		t.Errorf("exceptions are %q, want %q", got, want)
+	}
+}
+
+func TestCoreExceptionDepth(t *testing.T) {
+	core, transport := newTestCore(t, Configuration{Level: zapcore.ErrorLevel, DisableStacktrace: true})
+	err := errors.New("root")
+	for i := 0; i < 2*maxErrorDepth; i++ {
+		err = fmt.Errorf("wrap %d: %w", i, err)
+	}
+	writeEntry(t, core, zapcore.ErrorLevel, zap.Error(err))
+
+	got := exceptionValues(t, transport)
+	if len(got) != maxErrorDepth || got[len(got)-1] != err.Error() {
+		t.Errorf("got %d exceptions, want the %d most recent errors", len(got), maxErrorDepth)
+	}
+}
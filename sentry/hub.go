@@ -0,0 +1,32 @@
+package logsentry
+
+import (
+	"github.com/getsentry/sentry-go"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// hubFieldKey is the key of the field added by HubField.
+const hubFieldKey = "sentry.hub"
+
+// HubField returns a field carrying hub, used by the sentry core instead of
+// Configuration.Hub for the entries with the field. The other encoders skip
+// it. log.L adds it for the hub of the context, see sentry.SetHubOnContext.
+func HubField(hub *sentry.Hub) zap.Field {
+	return zap.Field{Key: hubFieldKey, Type: zapcore.SkipType, Interface: hub}
+}
+
+// fieldsHub returns the hub of the last field added by HubField, def if
+// there is none.
+func fieldsHub(def *sentry.Hub, fs []zapcore.Field) *sentry.Hub {
+	hub := def
+	for _, f := range fs {
+		if f.Type != zapcore.SkipType || f.Key != hubFieldKey {
+			continue
+		}
+		if h, ok := f.Interface.(*sentry.Hub); ok && h != nil {
+			hub = h
+		}
+	}
+	return hub
+}
@@ -0,0 +1,158 @@
+package logsentry
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// maxDedupKeys is the number of keys remembered for deduplication. Past it,
+// the key of the oldest event sent is forgotten.
+const maxDedupKeys = 1024
+
+// Stats are the counters of the events of a sentry core and of the cores
+// derived from it by With.
+type Stats struct {
+	// Sent is the number of events sent to the client.
+	Sent uint64
+	// RateLimited is the number of events dropped by the rate limit.
+	RateLimited uint64
+	// Duplicates is the number of events dropped in the dedup window.
+	Duplicates uint64
+}
+
+// StatsGetter is a interface of get the counters of a sentry core.
+type StatsGetter interface {
+	Stats() Stats
+}
+
+// Stats returns the counters of the events of the core.
+func (c *core) Stats() Stats {
+	return c.limiter.stats()
+}
+
+// limiter drops the events above the rate limit, with a token bucket, and
+// the events with the same message and caller as one sent less than a window
+// ago.
+type limiter struct {
+	rate   float64 // tokens per second, unlimited if zero
+	burst  float64
+	window time.Duration
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+	seen   map[dedupKey]*dedupEntry
+	// order holds the keys of seen, the oldest event sent first.
+	order *list.List
+	// limited is the number of events rate limited since the last event
+	// sent.
+	limited uint64
+	counts  Stats
+}
+
+type dedupKey struct {
+	message string
+	file    string
+	line    int
+}
+
+type dedupEntry struct {
+	sent    time.Time
+	dropped uint64
+	elem    *list.Element // of order
+}
+
+func newLimiter(rate float64, burst int, window time.Duration) *limiter {
+	l := &limiter{rate: rate, burst: float64(burst), window: window}
+	if l.burst < 1 {
+		l.burst = 1
+		if rate > 1 {
+			l.burst = rate
+		}
+	}
+	l.tokens = l.burst
+	return l
+}
+
+// allow reports whether the event of ent is to be sent, with the number of
+// its duplicates dropped since the last one sent and the number of events
+// rate limited since the last event sent. Panic and fatal entries are always
+// sent.
+func (l *limiter) allow(ent zapcore.Entry) (ok bool, duplicates, limited uint64) {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if ent.Level >= zapcore.PanicLevel {
+		limited = l.limited
+		l.limited = 0
+		l.counts.Sent++
+		return true, 0, limited
+	}
+
+	var key dedupKey
+	var seen *dedupEntry
+	if l.window > 0 {
+		key = dedupKey{message: ent.Message, file: ent.Caller.File, line: ent.Caller.Line}
+		seen = l.seen[key]
+		if seen != nil && now.Sub(seen.sent) < l.window {
+			seen.dropped++
+			l.counts.Duplicates++
+			return false, 0, 0
+		}
+	}
+
+	if l.rate > 0 {
+		if !l.last.IsZero() {
+			l.tokens += now.Sub(l.last).Seconds() * l.rate
+			if l.tokens > l.burst {
+				l.tokens = l.burst
+			}
+		}
+		l.last = now
+		if l.tokens < 1 {
+			l.limited++
+			l.counts.RateLimited++
+			return false, 0, 0
+		}
+		l.tokens--
+	}
+
+	if l.window > 0 {
+		if seen != nil {
+			duplicates = seen.dropped
+		}
+		l.remember(key, now)
+	}
+	limited = l.limited
+	l.limited = 0
+	l.counts.Sent++
+	return true, duplicates, limited
+}
+
+// remember records that the event of key was sent at now.
+func (l *limiter) remember(key dedupKey, now time.Time) {
+	if l.seen == nil {
+		l.seen = make(map[dedupKey]*dedupEntry)
+		l.order = list.New()
+	}
+	if e := l.seen[key]; e != nil {
+		e.sent, e.dropped = now, 0
+		l.order.MoveToBack(e.elem)
+		return
+	}
+	for len(l.seen) >= maxDedupKeys {
+		oldest := l.order.Front()
+		delete(l.seen, l.order.Remove(oldest).(dedupKey))
+	}
+	l.seen[key] = &dedupEntry{sent: now, elem: l.order.PushBack(key)}
+}
+
+func (l *limiter) stats() Stats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.counts
+}
@@ -0,0 +1,65 @@
+package logsentry
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"go.uber.org/zap/zapcore"
+)
+
+func TestLimiterDedupKeysCapped(t *testing.T) {
+	l := newLimiter(0, 0, time.Hour)
+	entry := func(i int) zapcore.Entry {
+		return zapcore.Entry{Level: zapcore.ErrorLevel, Message: "message " + strconv.Itoa(i)}
+	}
+	for i := 0; i < 3*maxDedupKeys; i++ {
+		if ok, _, _ := l.allow(entry(i)); !ok {
+			t.Fatalf("entry %d dropped", i)
+		}
+	}
+	if len(l.seen) != maxDedupKeys || l.order.Len() != maxDedupKeys {
+		t.Fatalf("remembered %d keys in a map and %d in a list, want %d", len(l.seen), l.order.Len(), maxDedupKeys)
+	}
+
+	// The oldest keys were forgotten, the latest are still deduplicated.
+	if ok, _, _ := l.allow(entry(0)); !ok {
+		t.Error("oldest entry still deduplicated")
+	}
+	if ok, _, _ := l.allow(entry(3*maxDedupKeys - 1)); ok {
+		t.Error("latest entry not deduplicated")
+	}
+	if got := l.stats(); got.Duplicates != 1 || got.Sent != 3*maxDedupKeys+1 {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestLimiterDedupResent(t *testing.T) {
+	l := newLimiter(0, 0, time.Hour)
+	again := zapcore.Entry{Level: zapcore.ErrorLevel, Message: "again"}
+	other := zapcore.Entry{Level: zapcore.ErrorLevel, Message: "other"}
+	l.allow(again)
+	l.allow(other)
+	for i := 0; i < 3; i++ {
+		if ok, _, _ := l.allow(again); ok {
+			t.Fatal("duplicate sent")
+		}
+	}
+
+	// Once the window is over, the key is sent with the number of
+	// duplicates, and becomes the newest.
+	l.seen[dedupKey{message: "again"}].sent = time.Now().Add(-2 * time.Hour)
+	ok, duplicates, _ := l.allow(again)
+	if !ok || duplicates != 3 {
+		t.Fatalf("got %v, %d duplicates, want true, 3", ok, duplicates)
+	}
+	for i := 0; i < maxDedupKeys-1; i++ {
+		l.allow(zapcore.Entry{Level: zapcore.ErrorLevel, Message: strconv.Itoa(i)})
+	}
+	if _, ok := l.seen[dedupKey{message: "other"}]; ok {
+		t.Error("oldest key remembered")
+	}
+	if _, ok := l.seen[dedupKey{message: "again"}]; !ok {
+		t.Error("resent key forgotten")
+	}
+}
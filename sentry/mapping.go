@@ -0,0 +1,96 @@
+package logsentry
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/getsentry/sentry-go"
+)
+
+// User attributes of Configuration.UserKeys.
+const (
+	UserID        = "id"
+	UserEmail     = "email"
+	UserUsername  = "username"
+	UserIPAddress = "ip_address"
+)
+
+// defaultFingerprint stands for the grouping sentry would do on its own.
+const defaultFingerprint = "{{ default }}"
+
+// mapFields moves the fields configured by TagKeys, UserKeys and ContextKeys
+// out of fields and into event, and sets the fingerprint of event from the
+// FingerprintKeys. Neither fields nor the tags of event may be shared.
+func (c *core) mapFields(event *sentry.Event, fields map[string]interface{}) {
+	for _, key := range c.cfg.TagKeys {
+		v, ok := fields[key]
+		if !ok {
+			continue
+		}
+		event.Tags[key] = fieldString(v)
+		delete(fields, key)
+	}
+
+	for attr, key := range c.cfg.UserKeys {
+		v, ok := fields[key]
+		if !ok {
+			continue
+		}
+		switch strings.ToLower(attr) {
+		case UserID:
+			event.User.ID = fieldString(v)
+		case UserEmail:
+			event.User.Email = fieldString(v)
+		case UserUsername:
+			event.User.Username = fieldString(v)
+		case UserIPAddress:
+			event.User.IPAddress = fieldString(v)
+		default:
+			continue
+		}
+		delete(fields, key)
+	}
+
+	for name, keys := range c.cfg.ContextKeys {
+		var context map[string]interface{}
+		for _, key := range keys {
+			v, ok := fields[key]
+			if !ok {
+				continue
+			}
+			if context == nil {
+				context = make(map[string]interface{}, len(keys))
+			}
+			// "http.method" is "method" in the "http" context.
+			context[strings.TrimPrefix(key, name+".")] = v
+			delete(fields, key)
+		}
+		if context != nil {
+			if event.Contexts == nil {
+				event.Contexts = make(map[string]interface{})
+			}
+			event.Contexts[name] = context
+		}
+	}
+
+	// The fingerprint fields stay in the extra data.
+	var fingerprint []string
+	for _, key := range c.cfg.FingerprintKeys {
+		if v, ok := fields[key]; ok {
+			if fingerprint == nil {
+				fingerprint = []string{defaultFingerprint}
+			}
+			fingerprint = append(fingerprint, fieldString(v))
+		}
+	}
+	if fingerprint != nil {
+		event.Fingerprint = fingerprint
+	}
+}
+
+func fieldString(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprint(v)
+}
@@ -0,0 +1,58 @@
+package logsentry
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/getsentry/sentry-go"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+func TestCoreMapsFields(t *testing.T) {
+	core, transport := newTestCore(t, Configuration{
+		Level:             zapcore.ErrorLevel,
+		DisableStacktrace: true,
+		Tags:              map[string]string{"component": "api"},
+		TagKeys:           []string{"tenant"},
+		UserKeys:          map[string]string{UserID: "user.id", UserEmail: "user.email"},
+		ContextKeys:       map[string][]string{"http": {"http.method", "http.path"}},
+		FingerprintKeys:   []string{"endpoint"},
+	})
+	writeEntry(t, core, zapcore.ErrorLevel,
+		zap.String("tenant", "acme"),
+		zap.Int("user.id", 42),
+		zap.String("user.email", "ann@example.com"),
+		zap.String("http.method", "POST"),
+		zap.String("http.path", "/charge"),
+		zap.String("endpoint", "charge"),
+		zap.Int("attempt", 2))
+
+	event := transport.lastEvent(t)
+	if want := map[string]string{"component": "api", "tenant": "acme"}; !reflect.DeepEqual(event.Tags, want) {
+		t.Errorf("tags are %v, want %v", event.Tags, want)
+	}
+	if want := (sentry.User{ID: "42", Email: "ann@example.com"}); event.User != want {
+		t.Errorf("user is %+v, want %+v", event.User, want)
+	}
+	wantContext := map[string]interface{}{"method": "POST", "path": "/charge"}
+	if got := event.Contexts["http"]; !reflect.DeepEqual(got, wantContext) {
+		t.Errorf("http context is %v, want %v", got, wantContext)
+	}
+	if want := []string{"{{ default }}", "charge"}; !reflect.DeepEqual(event.Fingerprint, want) {
+		t.Errorf("fingerprint is %q, want %q", event.Fingerprint, want)
+	}
+	// The mapped fields leave the extra data, but for the fingerprint.
+	wantExtra := map[string]interface{}{"endpoint": "charge", "attempt": int64(2)}
+	if !reflect.DeepEqual(event.Extra, wantExtra) {
+		t.Errorf("extra is %v, want %v", event.Extra, wantExtra)
+	}
+
+	// Events without the fields keep the default grouping and the
+	// configured tags only.
+	writeEntry(t, core, zapcore.ErrorLevel, zap.Int("attempt", 3))
+	event = transport.lastEvent(t)
+	if event.Fingerprint != nil || len(event.Tags) != 1 || event.User != (sentry.User{}) || event.Contexts["http"] != nil {
+		t.Errorf("event is %+v", event)
+	}
+}
@@ -0,0 +1,96 @@
+package logsentry
+
+import (
+	"runtime"
+	"strings"
+
+	"github.com/getsentry/sentry-go"
+	"go.uber.org/zap/zapcore"
+)
+
+// loggerPackages are the packages of the frames between the caller and the
+// core. The subpackages of zap are included.
+var loggerPackages = []string{
+	"go.uber.org/zap",
+	"github.com/liasece/log",
+	"github.com/liasece/log/sentry",
+}
+
+// stacktrace returns the stack of the caller of the entry, without the frames
+// of the logger so that sentry groups events by the application code.
+func (c *core) stacktrace(caller zapcore.EntryCaller) *sentry.Stacktrace {
+	pcs := make([]uintptr, 100)
+	n := runtime.Callers(2, pcs)
+	if n == 0 {
+		return nil
+	}
+	var stack []runtime.Frame
+	callers := runtime.CallersFrames(pcs[:n])
+	for {
+		f, more := callers.Next()
+		stack = append(stack, f)
+		if !more {
+			break
+		}
+	}
+
+	start := -1
+	if caller.Defined {
+		for i, f := range stack {
+			if f.PC == caller.PC || (f.File == caller.File && f.Line == caller.Line) {
+				start = i
+				break
+			}
+		}
+	}
+	if start < 0 {
+		// No caller, or not found: skip the frames of the logger.
+		start = 0
+		for start < len(stack)-1 && isLoggerFrame(stack[start]) {
+			start++
+		}
+	}
+
+	frames := make([]sentry.Frame, 0, len(stack)-start)
+	// Sentry expects the most recent call last.
+	for i := len(stack) - 1; i >= start; i-- {
+		frame := sentry.NewFrame(stack[i])
+		if frame.Module == "runtime" || frame.Module == "testing" {
+			continue
+		}
+		frames = append(frames, frame)
+	}
+	if len(frames) == 0 {
+		return nil
+	}
+	trace := &sentry.Stacktrace{Frames: frames}
+	c.markInApp(trace)
+	return trace
+}
+
+// markInApp marks the frames of the InAppExclude modules as not in app.
+func (c *core) markInApp(trace *sentry.Stacktrace) {
+	if trace == nil || len(c.cfg.InAppExclude) == 0 {
+		return
+	}
+	for i := range trace.Frames {
+		for _, prefix := range c.cfg.InAppExclude {
+			if strings.HasPrefix(trace.Frames[i].Module, prefix) {
+				trace.Frames[i].InApp = false
+				break
+			}
+		}
+	}
+}
+
+func isLoggerFrame(f runtime.Frame) bool {
+	if strings.HasPrefix(f.Function, "go.uber.org/zap/") {
+		return true
+	}
+	for _, pkg := range loggerPackages {
+		if strings.HasPrefix(f.Function, pkg+".") {
+			return true
+		}
+	}
+	return false
+}
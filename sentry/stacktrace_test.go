@@ -0,0 +1,70 @@
+package logsentry
+
+import (
+	"testing"
+
+	"github.com/getsentry/sentry-go"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// logFailure logs an error through a logger, like application code does.
+func logFailure(logger *zap.Logger) {
+	logger.Error("failed")
+}
+
+func lastFrames(t *testing.T, transport *fakeTransport) []sentry.Frame {
+	t.Helper()
+	exceptions := transport.lastEvent(t).Exception
+	if len(exceptions) != 1 || exceptions[0].Stacktrace == nil {
+		t.Fatalf("exceptions are %+v, want one with a stack trace", exceptions)
+	}
+	return exceptions[0].Stacktrace.Frames
+}
+
+func TestCoreStacktraceStartsAtCaller(t *testing.T) {
+	core, transport := newTestCore(t, Configuration{Level: zapcore.ErrorLevel})
+	logFailure(zap.New(core, zap.AddCaller()))
+
+	frames := lastFrames(t, transport)
+	if len(frames) < 2 {
+		t.Fatalf("got %d frames", len(frames))
+	}
+	last, prev := frames[len(frames)-1], frames[len(frames)-2]
+	if last.Function != "logFailure" || prev.Function != "TestCoreStacktraceStartsAtCaller" {
+		t.Errorf("last frames are %s.%s and %s.%s", prev.Module, prev.Function, last.Module, last.Function)
+	}
+	for _, f := range frames {
+		if f.Module == "go.uber.org/zap" || f.Module == "go.uber.org/zap/zapcore" {
+			t.Errorf("frame of the logger %s.%s", f.Module, f.Function)
+		}
+		if !f.InApp {
+			t.Errorf("frame %s.%s is not in app", f.Module, f.Function)
+		}
+	}
+}
+
+func TestCoreStacktraceWithCallerSkip(t *testing.T) {
+	core, transport := newTestCore(t, Configuration{Level: zapcore.ErrorLevel})
+	// The caller is the caller of logFailure, as for a logging helper.
+	logFailure(zap.New(core, zap.AddCaller(), zap.AddCallerSkip(1)))
+
+	frames := lastFrames(t, transport)
+	if last := frames[len(frames)-1]; last.Function != "TestCoreStacktraceWithCallerSkip" {
+		t.Errorf("last frame is %s.%s", last.Module, last.Function)
+	}
+}
+
+func TestCoreStacktraceInAppExclude(t *testing.T) {
+	core, transport := newTestCore(t, Configuration{
+		Level:        zapcore.ErrorLevel,
+		InAppExclude: []string{"github.com/liasece/log/sentry"},
+	})
+	logFailure(zap.New(core, zap.AddCaller()))
+
+	for _, f := range lastFrames(t, transport) {
+		if f.InApp {
+			t.Errorf("frame %s.%s is in app", f.Module, f.Function)
+		}
+	}
+}
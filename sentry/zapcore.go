@@ -14,7 +14,47 @@ type Configuration struct {
 	DisableStacktrace bool
 	Level             zapcore.Level
 	FlushTimeout      time.Duration
-	Hub               *sentry.Hub
+	// Hub is the hub of the entries without a HubField, sentry.CurrentHub
+	// if nil.
+	Hub *sentry.Hub
+
+	// EnableBreadcrumbs records the entries at and above BreadcrumbLevel,
+	// and below Level, as breadcrumbs on the scope of the hub. They are sent
+	// with the next event. The BeforeBreadcrumb and MaxBreadcrumbs options
+	// of the client of the hub apply.
+	EnableBreadcrumbs bool
+	BreadcrumbLevel   zapcore.Level
+
+	// InAppExclude are the prefixes of the modules whose frames are marked
+	// as not in app, such as the frameworks wrapping the application code.
+	InAppExclude []string
+
+	// TagKeys are the keys of the fields sent as tags instead of extra data.
+	TagKeys []string
+	// UserKeys maps the attributes of the user of the event, UserID,
+	// UserEmail, UserUsername or UserIPAddress, to the keys of the fields
+	// holding them, e.g. {UserID: "user.id"}.
+	UserKeys map[string]string
+	// ContextKeys maps the names of structured contexts to the keys of the
+	// fields that go into them, e.g. {"http": {"http.method", "http.path"}}.
+	ContextKeys map[string][]string
+	// FingerprintKeys are the keys of the fields whose values refine the
+	// default grouping of the events.
+	FingerprintKeys []string
+
+	// RateLimit is the number of events sent per second, with bursts of
+	// RateBurst events. Zero disables the limit. The panic and fatal entries
+	// are always sent.
+	RateLimit float64
+	RateBurst int
+	// DedupWindow drops the events with the same message and caller as an
+	// event sent less than DedupWindow ago. The next event of the same
+	// message and caller that is sent reports the number of events dropped
+	// before it. Duplicates that are not followed by such an event, because
+	// the event doesn't recur or its key is forgotten past the last 1024 keys
+	// sent, are only counted in the Duplicates of Stats. Zero disables the
+	// deduplication.
+	DedupWindow time.Duration
 }
 
 // NewCore creates a zap core that writes logs to a sentry client.
@@ -29,12 +69,15 @@ func NewCore(cfg Configuration, factory SentryClientFactory) (zapcore.Core, erro
 		cfg:          &cfg,
 		LevelEnabler: cfg.Level,
 		flushTimeout: 5 * time.Second,
-		fields:       make(map[string]interface{}),
+		limiter:      newLimiter(cfg.RateLimit, cfg.RateBurst, cfg.DedupWindow),
 	}
 
 	if cfg.FlushTimeout > 0 {
 		core.flushTimeout = cfg.FlushTimeout
 	}
+	if cfg.EnableBreadcrumbs && cfg.BreadcrumbLevel < cfg.Level {
+		core.LevelEnabler = cfg.BreadcrumbLevel
+	}
 
 	return &core, nil
 }
@@ -44,25 +87,68 @@ func (c *core) With(fs []zapcore.Field) zapcore.Core {
 }
 
 func (c *core) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
-	if c.cfg.Level.Enabled(ent.Level) {
+	if c.LevelEnabler.Enabled(ent.Level) {
 		return ce.AddCore(ent, c)
 	}
 	return ce
 }
 
 func (c *core) Write(ent zapcore.Entry, fs []zapcore.Field) error {
-	clone := c.with(fs)
+	fields := c.fields
+	if len(fs) > 0 {
+		fields = append(fields[:len(fields):len(fields)], fs...)
+	}
+
+	hub := fieldsHub(c.cfg.Hub, fields)
+	if hub == nil {
+		hub = sentry.CurrentHub()
+	}
+	if !c.cfg.Level.Enabled(ent.Level) {
+		c.addBreadcrumb(hub, ent, encodeFields(fields))
+		return nil
+	}
+	ok, duplicates, limited := c.limiter.allow(ent)
+	if !ok {
+		return nil
+	}
 
 	event := sentry.NewEvent()
 	event.Message = ent.Message
 	event.Timestamp = ent.Time
 	event.Level = sentrySeverity(ent.Level)
 	event.Platform = "Golang"
-	event.Extra = clone.fields
-	event.Tags = c.cfg.Tags
+	event.Extra = encodeFields(fields)
+	// The scope of the hub writes its tags into the event, the configured
+	// ones are shared by all the events.
+	event.Tags = make(map[string]string, len(c.cfg.Tags)+len(c.cfg.TagKeys))
+	for k, v := range c.cfg.Tags {
+		event.Tags[k] = v
+	}
+	c.mapFields(event, event.Extra)
+	if duplicates > 0 {
+		event.Extra["sentry.duplicates"] = duplicates
+	}
+	if limited > 0 {
+		event.Extra["sentry.rate_limited"] = limited
+	}
 
-	if !c.cfg.DisableStacktrace {
-		trace := sentry.NewStacktrace()
+	var exceptions []sentry.Exception
+	for _, err := range fieldErrors(fields) {
+		exceptions = append(exceptions, errorExceptions(err, !c.cfg.DisableStacktrace)...)
+	}
+	if len(exceptions) > 0 {
+		for i := range exceptions {
+			c.markInApp(exceptions[i].Stacktrace)
+		}
+		// The stack of the caller goes to the most recent error, unless it
+		// carries its own.
+		last := &exceptions[len(exceptions)-1]
+		if !c.cfg.DisableStacktrace && last.Stacktrace == nil {
+			last.Stacktrace = c.stacktrace(ent.Caller)
+		}
+		event.Exception = exceptions
+	} else if !c.cfg.DisableStacktrace {
+		trace := c.stacktrace(ent.Caller)
 		if trace != nil {
 			event.Exception = []sentry.Exception{{
 				Type:       ent.Message,
@@ -72,10 +158,6 @@ func (c *core) Write(ent zapcore.Entry, fs []zapcore.Field) error {
 		}
 	}
 
-	hub := c.cfg.Hub
-	if hub == nil {
-		hub = sentry.CurrentHub()
-	}
 	_ = c.client.CaptureEvent(event, nil, hub.Scope())
 
 	// We may be crashing the program, so should flush any buffered events.
@@ -85,35 +167,49 @@ func (c *core) Write(ent zapcore.Entry, fs []zapcore.Field) error {
 	return nil
 }
 
+// addBreadcrumb records an entry below Level on the scope of hub.
+func (c *core) addBreadcrumb(hub *sentry.Hub, ent zapcore.Entry, fields map[string]interface{}) {
+	category := ent.LoggerName
+	if category == "" {
+		category = "log"
+	}
+	hub.AddBreadcrumb(&sentry.Breadcrumb{
+		Type:      "default",
+		Category:  category,
+		Message:   ent.Message,
+		Data:      fields,
+		Level:     sentrySeverity(ent.Level),
+		Timestamp: ent.Time,
+	}, nil)
+}
+
 func (c *core) Sync() error {
 	c.client.Flush(c.flushTimeout)
 	return nil
 }
 
+// Close sends the buffered events, like Sync. The core can still be used
+// afterwards.
+func (c *core) Close() error {
+	return c.Sync()
+}
+
+// with keeps the fields as they are, they are only encoded by Write for the
+// entries that are sent.
 func (c *core) with(fs []zapcore.Field) *core {
-	// Copy our map.
-	m := make(map[string]interface{}, len(c.fields))
-	for k, v := range c.fields {
-		m[k] = v
-	}
+	clone := *c
+	clone.fields = append(c.fields[:len(c.fields):len(c.fields)], fs...)
+	return &clone
+}
 
-	// Add fields to an in-memory encoder.
+// encodeFields returns the fields as the extra data of an event. The fields
+// added after a namespace are nested in it.
+func encodeFields(fields []zapcore.Field) map[string]interface{} {
 	enc := zapcore.NewMapObjectEncoder()
-	for _, f := range fs {
+	for _, f := range fields {
 		f.AddTo(enc)
 	}
-
-	// Merge the two maps.
-	for k, v := range enc.Fields {
-		m[k] = v
-	}
-
-	return &core{
-		client:       c.client,
-		cfg:          c.cfg,
-		fields:       m,
-		LevelEnabler: c.LevelEnabler,
-	}
+	return enc.Fields
 }
 
 // ClientGetter is a interface of get sentry client.
@@ -131,7 +227,10 @@ type core struct {
 	zapcore.LevelEnabler
 	flushTimeout time.Duration
 
-	fields map[string]interface{}
+	// fields are the fields added by With, not encoded yet.
+	fields []zapcore.Field
+	// limiter is shared by the cores derived by With.
+	limiter *limiter
 }
 
 // AttachCoreToLogger append a zap core to zap logger
@@ -0,0 +1,232 @@
+package logsentry
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/getsentry/sentry-go"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// fakeTransport records the events and the flushes of a client.
+type fakeTransport struct {
+	mu      sync.Mutex
+	events  []*sentry.Event
+	flushes []time.Duration
+}
+
+func (t *fakeTransport) Configure(sentry.ClientOptions) {}
+
+func (t *fakeTransport) SendEvent(event *sentry.Event) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.events = append(t.events, event)
+}
+
+func (t *fakeTransport) Flush(timeout time.Duration) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.flushes = append(t.flushes, timeout)
+	return true
+}
+
+func (t *fakeTransport) lastEvent(tb testing.TB) *sentry.Event {
+	tb.Helper()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.events) == 0 {
+		tb.Fatal("no event sent")
+	}
+	return t.events[len(t.events)-1]
+}
+
+func newTestCore(t *testing.T, cfg Configuration) (zapcore.Core, *fakeTransport) {
+	t.Helper()
+	return newTestCoreWithOptions(t, cfg, sentry.ClientOptions{})
+}
+
+func newTestCoreWithOptions(t *testing.T, cfg Configuration, options sentry.ClientOptions) (zapcore.Core, *fakeTransport) {
+	t.Helper()
+	transport := &fakeTransport{}
+	options.Transport = transport
+	client, err := sentry.NewClient(options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Hub == nil {
+		cfg.Hub = sentry.NewHub(client, sentry.NewScope())
+	}
+	core, err := NewCore(cfg, NewSentryClientFromClient(client))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return core, transport
+}
+
+func writeEntry(t *testing.T, core zapcore.Core, level zapcore.Level, fields ...zap.Field) {
+	t.Helper()
+	ent := zapcore.Entry{Level: level, Time: time.Now(), Message: "failed"}
+	if err := core.Write(ent, fields); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCoreWithInheritsFields(t *testing.T) {
+	core, transport := newTestCore(t, Configuration{Level: zapcore.ErrorLevel, DisableStacktrace: true})
+	parent := core.With([]zap.Field{zap.String("service", "api")})
+	child := parent.With([]zap.Field{zap.Int("attempt", 2)})
+	sibling := parent.With([]zap.Field{zap.Int("attempt", 3)})
+
+	writeEntry(t, child, zapcore.ErrorLevel, zap.Bool("retry", true))
+	want := map[string]interface{}{"service": "api", "attempt": int64(2), "retry": true}
+	if got := transport.lastEvent(t).Extra; !reflect.DeepEqual(got, want) {
+		t.Errorf("child: got %v, want %v", got, want)
+	}
+
+	writeEntry(t, sibling, zapcore.ErrorLevel)
+	want = map[string]interface{}{"service": "api", "attempt": int64(3)}
+	if got := transport.lastEvent(t).Extra; !reflect.DeepEqual(got, want) {
+		t.Errorf("sibling: got %v, want %v", got, want)
+	}
+
+	writeEntry(t, parent, zapcore.ErrorLevel)
+	want = map[string]interface{}{"service": "api"}
+	if got := transport.lastEvent(t).Extra; !reflect.DeepEqual(got, want) {
+		t.Errorf("parent: got %v, want %v", got, want)
+	}
+}
+
+func TestCoreWithNamespace(t *testing.T) {
+	core, transport := newTestCore(t, Configuration{Level: zapcore.ErrorLevel, DisableStacktrace: true})
+	core = core.With([]zap.Field{zap.String("service", "api"), zap.Namespace("request")})
+	core = core.With([]zap.Field{zap.String("method", "GET")})
+
+	writeEntry(t, core, zapcore.ErrorLevel, zap.Int("status", 500), zap.Namespace("db"), zap.String("table", "users"))
+	want := map[string]interface{}{
+		"service": "api",
+		"request": map[string]interface{}{
+			"method": "GET",
+			"status": int64(500),
+			"db":     map[string]interface{}{"table": "users"},
+		},
+	}
+	if got := transport.lastEvent(t).Extra; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestCoreWithFlushTimeout(t *testing.T) {
+	core, transport := newTestCore(t, Configuration{
+		Level:             zapcore.ErrorLevel,
+		DisableStacktrace: true,
+		FlushTimeout:      42 * time.Millisecond,
+	})
+	derived := core.With([]zap.Field{zap.String("service", "api")})
+
+	writeEntry(t, derived, zapcore.ErrorLevel)
+	if len(transport.flushes) != 0 {
+		t.Fatalf("error entry flushed %v", transport.flushes)
+	}
+	writeEntry(t, derived, zapcore.FatalLevel)
+	if err := derived.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	want := []time.Duration{42 * time.Millisecond, 42 * time.Millisecond}
+	if !reflect.DeepEqual(transport.flushes, want) {
+		t.Errorf("got flushes %v, want %v", transport.flushes, want)
+	}
+}
+
+func TestCoreDefaultFlushTimeout(t *testing.T) {
+	core, transport := newTestCore(t, Configuration{Level: zapcore.ErrorLevel})
+	if err := core.With(nil).Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if want := []time.Duration{5 * time.Second}; !reflect.DeepEqual(transport.flushes, want) {
+		t.Errorf("got flushes %v, want %v", transport.flushes, want)
+	}
+}
+
+func TestCoreTags(t *testing.T) {
+	tags := map[string]string{"component": "system"}
+	c, transport := newTestCore(t, Configuration{
+		Level:             zapcore.ErrorLevel,
+		DisableStacktrace: true,
+		Tags:              tags,
+		TagKeys:           []string{"endpoint"},
+	})
+	client := c.(ClientGetter).GetClient()
+
+	// Hubs of concurrent requests write their scope tags into the events.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			scope := sentry.NewScope()
+			scope.SetTag("request", string(rune('a'+i)))
+			hub := sentry.NewHub(client, scope)
+			ent := zapcore.Entry{Level: zapcore.ErrorLevel, Message: "failed"}
+			for j := 0; j < 50; j++ {
+				_ = c.Write(ent, []zap.Field{HubField(hub)})
+			}
+		}(i)
+	}
+	wg.Wait()
+	if want := map[string]string{"component": "system"}; !reflect.DeepEqual(tags, want) {
+		t.Errorf("configured tags changed to %v", tags)
+	}
+
+	writeEntry(t, c, zapcore.ErrorLevel, zap.String("endpoint", "/users"), zap.Error(errors.New("boom")))
+	event := transport.lastEvent(t)
+	if want := map[string]string{"component": "system", "endpoint": "/users"}; !reflect.DeepEqual(event.Tags, want) {
+		t.Errorf("got tags %v, want %v", event.Tags, want)
+	}
+	if _, ok := event.Extra["endpoint"]; ok {
+		t.Error("tag left in the extra data")
+	}
+}
+
+func TestCoreBreadcrumbs(t *testing.T) {
+	core, transport := newTestCoreWithOptions(t, Configuration{
+		Level:             zapcore.ErrorLevel,
+		EnableBreadcrumbs: true,
+		BreadcrumbLevel:   zapcore.InfoLevel,
+		DisableStacktrace: true,
+	}, sentry.ClientOptions{
+		MaxBreadcrumbs: 2,
+		BeforeBreadcrumb: func(b *sentry.Breadcrumb, _ *sentry.BreadcrumbHint) *sentry.Breadcrumb {
+			if b.Message == "secret" {
+				return nil
+			}
+			return b
+		},
+	})
+	for _, msg := range []string{"one", "secret", "two", "three"} {
+		ent := zapcore.Entry{Level: zapcore.InfoLevel, Time: time.Now(), Message: msg, LoggerName: "payments"}
+		if err := core.Write(ent, []zap.Field{zap.Int("n", 1)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !core.Enabled(zapcore.InfoLevel) || core.Enabled(zapcore.DebugLevel) {
+		t.Error("the core is not enabled from the breadcrumb level")
+	}
+	writeEntry(t, core, zapcore.ErrorLevel)
+
+	crumbs := transport.lastEvent(t).Breadcrumbs
+	var msgs []string
+	for _, b := range crumbs {
+		msgs = append(msgs, b.Message)
+	}
+	if !reflect.DeepEqual(msgs, []string{"two", "three"}) {
+		t.Fatalf("breadcrumbs are %q, want the last two kept by BeforeBreadcrumb", msgs)
+	}
+	b := crumbs[1]
+	if b.Category != "payments" || b.Level != sentry.LevelInfo || !reflect.DeepEqual(b.Data, map[string]interface{}{"n": int64(1)}) {
+		t.Errorf("breadcrumb is %+v", b)
+	}
+}
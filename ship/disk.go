@@ -0,0 +1,292 @@
+package logship
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	segmentSuffix = ".seg"
+	// lockName is the file locked by the queue, so that two writers don't
+	// share the directory.
+	lockName = "lock"
+)
+
+// segment is a file of the disk queue: records made of the length of an
+// entry as a big-endian uint32, then the entry.
+type segment struct {
+	seq   uint64
+	size  int64
+	count int
+}
+
+// diskQueue keeps the entries that could not be sent in segment files, the
+// oldest dropped to stay under maxSize. It is used by one goroutine.
+type diskQueue struct {
+	dir         string
+	lock        *os.File
+	maxSize     int64
+	segmentSize int64
+	// drop is called with the number of entries dropped.
+	drop func(n int)
+
+	segments []segment // oldest first
+	size     int64     // of the segments
+	count    int       // entries not yet sent
+
+	// w appends to the last segment, nil once the segment is full or read.
+	w       *os.File
+	scratch []byte
+
+	// r reads the first segment, of which read entries were sent.
+	r     *bufio.Reader
+	rf    *os.File
+	read  int
+	batch [][]byte // returned by peek and not yet committed
+}
+
+// openDiskQueue opens the queue in dir, with the segments left by a previous
+// run. It fails if another queue is open in dir.
+func openDiskQueue(dir string, maxSize, segmentSize int64, drop func(int)) (_ *diskQueue, err error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("logship: %w", err)
+	}
+	lock, err := os.OpenFile(filepath.Join(dir, lockName), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("logship: %w", err)
+	}
+	if err := lockFile(lock); err != nil {
+		_ = lock.Close()
+		return nil, fmt.Errorf("logship: %s is used by another writer: %w", dir, err)
+	}
+	defer func() {
+		if err != nil {
+			_ = lock.Close()
+		}
+	}()
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("logship: %w", err)
+	}
+	q := &diskQueue{dir: dir, lock: lock, maxSize: maxSize, segmentSize: segmentSize, drop: drop}
+	for _, info := range infos {
+		name := info.Name()
+		if !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		count, err := countRecords(filepath.Join(dir, name))
+		if err != nil {
+			return nil, fmt.Errorf("logship: %w", err)
+		}
+		if count == 0 {
+			_ = os.Remove(filepath.Join(dir, name))
+			continue
+		}
+		q.segments = append(q.segments, segment{seq: seq, size: info.Size(), count: count})
+		q.size += info.Size()
+		q.count += count
+	}
+	sort.Slice(q.segments, func(i, j int) bool { return q.segments[i].seq < q.segments[j].seq })
+	return q, nil
+}
+
+// countRecords counts the complete records of a segment. A record cut short
+// by a crash ends the segment.
+func countRecords(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	count := 0
+	var header [4]byte
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			return count, nil
+		}
+		n := int64(binary.BigEndian.Uint32(header[:]))
+		if skipped, _ := r.Discard(int(n)); int64(skipped) < n {
+			return count, nil
+		}
+		count++
+	}
+}
+
+func (q *diskQueue) path(seq uint64) string {
+	return filepath.Join(q.dir, fmt.Sprintf("%020d%s", seq, segmentSuffix))
+}
+
+// append writes entries after the others. The oldest entries are dropped to
+// make room, and the entries larger than maxSize.
+func (q *diskQueue) append(entries [][]byte) error {
+	for i, e := range entries {
+		rec := int64(4 + len(e))
+		if rec > q.maxSize {
+			q.drop(1)
+			continue
+		}
+		if q.w != nil && q.segments[len(q.segments)-1].size+rec > q.segmentSize {
+			q.closeWriter()
+		}
+		for q.size+rec > q.maxSize {
+			q.dropOldest()
+		}
+		if q.w == nil {
+			if err := q.newSegment(); err != nil {
+				q.drop(len(entries) - i)
+				return err
+			}
+		}
+		q.scratch = append(q.scratch[:0], 0, 0, 0, 0)
+		binary.BigEndian.PutUint32(q.scratch, uint32(len(e)))
+		q.scratch = append(q.scratch, e...)
+		if _, err := q.w.Write(q.scratch); err != nil {
+			// The segment may end with part of the record, it ends there.
+			q.closeWriter()
+			q.drop(len(entries) - i)
+			return fmt.Errorf("logship: %w", err)
+		}
+		last := &q.segments[len(q.segments)-1]
+		last.size += rec
+		last.count++
+		q.size += rec
+		q.count++
+	}
+	return nil
+}
+
+func (q *diskQueue) newSegment() error {
+	var seq uint64 = 1
+	if len(q.segments) > 0 {
+		seq = q.segments[len(q.segments)-1].seq + 1
+	}
+	f, err := os.OpenFile(q.path(seq), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return fmt.Errorf("logship: %w", err)
+	}
+	q.w = f
+	q.segments = append(q.segments, segment{seq: seq})
+	return nil
+}
+
+func (q *diskQueue) closeWriter() {
+	if q.w != nil {
+		_ = q.w.Close()
+		q.w = nil
+	}
+}
+
+func (q *diskQueue) closeReader() {
+	if q.rf != nil {
+		_ = q.rf.Close()
+		q.rf, q.r = nil, nil
+	}
+	q.read = 0
+	q.batch = nil
+}
+
+// dropOldest removes the first segment, with its entries not yet sent.
+func (q *diskQueue) dropOldest() {
+	if len(q.segments) == 1 {
+		q.closeWriter()
+	}
+	seg := q.segments[0]
+	q.drop(seg.count - q.read)
+	q.count -= seg.count - q.read
+	q.removeFirst()
+}
+
+func (q *diskQueue) removeFirst() {
+	q.closeReader()
+	seg := q.segments[0]
+	_ = os.Remove(q.path(seg.seq))
+	q.size -= seg.size
+	q.segments = q.segments[1:]
+}
+
+// peek returns up to n of the oldest entries, again until commit is called.
+// The segment is dropped if it can't be read.
+func (q *diskQueue) peek(n int) ([][]byte, error) {
+	if q.batch != nil {
+		return q.batch, nil
+	}
+	if len(q.segments) == 1 {
+		// Entries are not appended to the segment being read.
+		q.closeWriter()
+	}
+	// Skip the segments left empty by a failed write.
+	for len(q.segments) > 0 && q.segments[0].count == 0 {
+		q.removeFirst()
+	}
+	if len(q.segments) == 0 {
+		return nil, nil
+	}
+	seg := q.segments[0]
+	if q.rf == nil {
+		f, err := os.Open(q.path(seg.seq))
+		if err != nil {
+			q.dropOldest()
+			return nil, fmt.Errorf("logship: %w", err)
+		}
+		q.rf, q.r = f, bufio.NewReader(f)
+	}
+	var header [4]byte
+	for len(q.batch) < n && q.read+len(q.batch) < seg.count {
+		if _, err := io.ReadFull(q.r, header[:]); err != nil {
+			q.batch = nil
+			q.dropOldest()
+			return nil, fmt.Errorf("logship: %s: %w", q.path(seg.seq), err)
+		}
+		e := make([]byte, binary.BigEndian.Uint32(header[:]))
+		if _, err := io.ReadFull(q.r, e); err != nil {
+			q.batch = nil
+			q.dropOldest()
+			return nil, fmt.Errorf("logship: %s: %w", q.path(seg.seq), err)
+		}
+		q.batch = append(q.batch, e)
+	}
+	return q.batch, nil
+}
+
+// commit removes the entries returned by peek.
+func (q *diskQueue) commit() {
+	q.read += len(q.batch)
+	q.count -= len(q.batch)
+	q.batch = nil
+	if len(q.segments) > 0 && q.read >= q.segments[0].count {
+		q.removeFirst()
+	}
+}
+
+// sync commits the segment being written to the disk.
+func (q *diskQueue) sync() error {
+	if q.w == nil {
+		return nil
+	}
+	if err := q.w.Sync(); err != nil {
+		return fmt.Errorf("logship: %w", err)
+	}
+	return nil
+}
+
+// close closes the files and releases the directory.
+func (q *diskQueue) close() error {
+	q.closeReader()
+	err := q.sync()
+	q.closeWriter()
+	_ = q.lock.Close()
+	return err
+}
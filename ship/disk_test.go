@@ -0,0 +1,144 @@
+package logship
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func diskEntries(from, to int) [][]byte {
+	var entries [][]byte
+	for i := from; i < to; i++ {
+		entries = append(entries, []byte(entry(i)))
+	}
+	return entries
+}
+
+// drain reads and commits every entry of q.
+func drain(t *testing.T, q *diskQueue, n int) []string {
+	t.Helper()
+	var got []string
+	for q.count > 0 {
+		batch, err := q.peek(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range batch {
+			got = append(got, string(e))
+		}
+		q.commit()
+	}
+	return got
+}
+
+func TestDiskQueueOrder(t *testing.T) {
+	dir := tempDir(t)
+	dropped := 0
+	// 14 bytes per record, 5 records per segment.
+	q, err := openDiskQueue(dir, 1000, 70, func(n int) { dropped += n })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.append(diskEntries(0, 12)); err != nil {
+		t.Fatal(err)
+	}
+	if len(q.segments) != 3 {
+		t.Errorf("got %d segments, want 3", len(q.segments))
+	}
+	// Entries appended while reading go after the others.
+	batch, err := q.peek(4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(batch) != 4 || string(batch[0]) != entry(0) {
+		t.Fatalf("got %q", batch)
+	}
+	q.commit()
+	if err := q.append(diskEntries(12, 20)); err != nil {
+		t.Fatal(err)
+	}
+	checkOrder(t, drain(t, q, 3), 4, 20)
+	if dropped != 0 || q.size != 0 {
+		t.Errorf("dropped %d, size %d", dropped, q.size)
+	}
+	if err := q.close(); err != nil {
+		t.Fatal(err)
+	}
+	segments, _ := filepath.Glob(filepath.Join(dir, "*"+segmentSuffix))
+	if len(segments) != 0 {
+		t.Errorf("segments left: %v", segments)
+	}
+}
+
+func TestDiskQueueCap(t *testing.T) {
+	dir := tempDir(t)
+	dropped := 0
+	q, err := openDiskQueue(dir, 280, 70, func(n int) { dropped += n })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.close()
+	if err := q.append(diskEntries(0, 100)); err != nil {
+		t.Fatal(err)
+	}
+	if q.size > 280 {
+		t.Errorf("queue of %d bytes over its cap", q.size)
+	}
+	// The oldest segments are dropped, whole.
+	if dropped != 80 || q.count != 20 {
+		t.Errorf("dropped %d, kept %d, want 80 and 20", dropped, q.count)
+	}
+	var size int64
+	infos, _ := ioutil.ReadDir(dir)
+	for _, info := range infos {
+		if strings.HasSuffix(info.Name(), segmentSuffix) {
+			size += info.Size()
+		}
+	}
+	if size != q.size {
+		t.Errorf("%d bytes on disk, queue of %d", size, q.size)
+	}
+
+	// An entry larger than the queue is dropped alone.
+	if err := q.append([][]byte{bytes.Repeat([]byte("x"), 300)}); err != nil {
+		t.Fatal(err)
+	}
+	if dropped != 81 {
+		t.Errorf("dropped %d, want 81", dropped)
+	}
+	checkOrder(t, drain(t, q, 7), 80, 100)
+}
+
+func TestDiskQueueReopen(t *testing.T) {
+	dir := tempDir(t)
+	q, err := openDiskQueue(dir, 1000, 70, func(int) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.append(diskEntries(0, 12)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.peek(2); err != nil {
+		t.Fatal(err)
+	}
+	q.commit()
+	if err := q.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The entries of a partly sent segment are sent again.
+	q, err = openDiskQueue(dir, 1000, 70, func(int) {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.close()
+	if q.count != 12 {
+		t.Fatalf("reopened %d entries", q.count)
+	}
+	if err := q.append(diskEntries(12, 14)); err != nil {
+		t.Fatal(err)
+	}
+	checkOrder(t, drain(t, q, 5), 0, 14)
+}
@@ -0,0 +1,12 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd && !windows
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd,!windows
+
+package logship
+
+import "os"
+
+// lockFile does nothing, files are not locked on this platform. The
+// directory of the queue must not be shared.
+func lockFile(f *os.File) error {
+	return nil
+}
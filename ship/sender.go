@@ -0,0 +1,128 @@
+package logship
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// sender delivers batches of encoded entries to the collector.
+type sender interface {
+	send(batch [][]byte) error
+	close() error
+}
+
+// rejectedError is returned by senders when the collector refuses a batch
+// for good: sending it again would fail the same.
+type rejectedError struct {
+	err error
+}
+
+func (e *rejectedError) Error() string { return e.err.Error() }
+func (e *rejectedError) Unwrap() error { return e.err }
+
+func newSender(cfg Configuration) (sender, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("logship: %w", err)
+	}
+	switch u.Scheme {
+	case "tcp":
+		if u.Host == "" {
+			return nil, fmt.Errorf("logship: no address in %q", cfg.URL)
+		}
+		return &tcpSender{addr: u.Host, timeout: cfg.Timeout}, nil
+	case "http", "https":
+		return &httpSender{
+			url:         cfg.URL,
+			contentType: cfg.ContentType,
+			headers:     cfg.Headers,
+			client:      &http.Client{Timeout: cfg.Timeout},
+		}, nil
+	default:
+		return nil, fmt.Errorf("logship: unsupported scheme %q, use tcp, http or https", u.Scheme)
+	}
+}
+
+// tcpSender writes the entries one after the other on a connection, opened
+// when needed. A batch is sent once written, TCP doesn't acknowledge it.
+type tcpSender struct {
+	addr    string
+	timeout time.Duration
+	conn    net.Conn
+}
+
+func (s *tcpSender) send(batch [][]byte) error {
+	if s.conn == nil {
+		conn, err := net.DialTimeout("tcp", s.addr, s.timeout)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+	}
+	_ = s.conn.SetWriteDeadline(time.Now().Add(s.timeout))
+	// WriteTo consumes the buffers, batch is kept whole to be sent again.
+	bufs := append(net.Buffers(nil), batch...)
+	if _, err := bufs.WriteTo(s.conn); err != nil {
+		_ = s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+func (s *tcpSender) close() error {
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// httpSender posts each batch, the entries one after the other.
+type httpSender struct {
+	url         string
+	contentType string
+	headers     map[string]string
+	client      *http.Client
+}
+
+func (s *httpSender) send(batch [][]byte) error {
+	req, err := http.NewRequest(http.MethodPost, s.url, bytes.NewReader(bytes.Join(batch, nil)))
+	if err != nil {
+		return &rejectedError{err}
+	}
+	req.Header.Set("Content-Type", s.contentType)
+	for k, v := range s.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	_ = resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		return nil
+	}
+	err = fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(body))
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests,
+		resp.StatusCode == http.StatusRequestTimeout,
+		resp.StatusCode >= 500:
+		return err
+	default:
+		return &rejectedError{err}
+	}
+}
+
+func (s *httpSender) close() error {
+	s.client.CloseIdleConnections()
+	return nil
+}
@@ -0,0 +1,51 @@
+package logship
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+// failingConn accepts limit bytes, then fails.
+type failingConn struct {
+	net.Conn
+	limit   int
+	written []byte
+}
+
+func (c *failingConn) Write(p []byte) (int, error) {
+	n := len(p)
+	if n > c.limit {
+		n = c.limit
+	}
+	c.limit -= n
+	c.written = append(c.written, p[:n]...)
+	if n < len(p) {
+		return n, errors.New("connection reset")
+	}
+	return n, nil
+}
+
+func (c *failingConn) SetWriteDeadline(time.Time) error { return nil }
+func (c *failingConn) Close() error                     { return nil }
+
+func TestTCPSenderKeepsBatchOnPartialWrite(t *testing.T) {
+	batch := [][]byte{[]byte(entry(0)), []byte(entry(1)), []byte(entry(2))}
+	conn := &failingConn{limit: len(entry(0)) + 3}
+	s := &tcpSender{addr: "127.0.0.1:0", timeout: time.Second, conn: conn}
+	if err := s.send(batch); err == nil {
+		t.Fatal("no error from a failed write")
+	}
+	if string(conn.written) != entry(0)+entry(1)[:3] {
+		t.Errorf("wrote %q", conn.written)
+	}
+	for i, e := range batch {
+		if string(e) != entry(i) {
+			t.Errorf("entry %d of the batch is now %q", i, e)
+		}
+	}
+	if s.conn != nil {
+		t.Error("failed connection kept")
+	}
+}
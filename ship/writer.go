@@ -0,0 +1,450 @@
+package logship
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultContentType is the content type of the batches posted over
+	// HTTP by default, for entries encoded as JSON.
+	DefaultContentType = "application/x-ndjson"
+	// DefaultBatchSize is the number of entries sent at once by default.
+	DefaultBatchSize = 500
+	// DefaultBatchInterval is how long entries wait for a batch by default.
+	DefaultBatchInterval = time.Second
+	// DefaultMinBackoff is the first delay before retrying by default.
+	DefaultMinBackoff = 500 * time.Millisecond
+	// DefaultMaxBackoff is the longest delay before retrying by default.
+	DefaultMaxBackoff = time.Minute
+	// DefaultTimeout is the default timeout to connect and send a batch.
+	DefaultTimeout = 10 * time.Second
+	// DefaultQueueSize is the number of entries kept in memory by default.
+	DefaultQueueSize = 10000
+	// DefaultMaxDiskSize is the default disk usage of the queue in megabytes.
+	DefaultMaxDiskSize = 1024
+	// DefaultSegmentSize is the default size of the queue files in megabytes.
+	DefaultSegmentSize = 16
+
+	megabyte = 1024 * 1024
+)
+
+// Configuration is the set of parameters of a shipping writer.
+type Configuration struct {
+	// URL is the collector: tcp://host:port to stream the entries, or an
+	// http or https URL to post them in batches.
+	URL string
+	// ContentType of the batches posted, DefaultContentType if empty.
+	ContentType string
+	// Headers are added to the requests, such as Authorization.
+	Headers map[string]string
+	// BatchSize is the maximum number of entries sent at once,
+	// DefaultBatchSize if zero.
+	BatchSize int
+	// BatchInterval is how long entries wait for a full batch,
+	// DefaultBatchInterval if zero.
+	BatchInterval time.Duration
+	// MinBackoff and MaxBackoff bound the delay before retrying, which
+	// doubles with every failure. DefaultMinBackoff and DefaultMaxBackoff if
+	// zero.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+	// Timeout bounds connecting and sending a batch, DefaultTimeout if zero.
+	Timeout time.Duration
+	// QueueSize is the maximum number of entries kept in memory,
+	// DefaultQueueSize if zero. The entries written while it is full are
+	// dropped.
+	QueueSize int
+	// Dir keeps the entries while the collector is unavailable, they are
+	// sent from there once it is back, and by the next run if the program
+	// exits first. The entries stay in memory if empty.
+	Dir string
+	// MaxDiskSize is the maximum size in megabytes of the files in Dir, the
+	// oldest entries are dropped beyond. DefaultMaxDiskSize if zero.
+	MaxDiskSize int
+	// SegmentSize is the size in megabytes of the files in Dir,
+	// DefaultSegmentSize if zero, at most a quarter of MaxDiskSize. The
+	// oldest file is dropped when the queue is full.
+	SegmentSize int
+}
+
+// Stats are the counters of a Writer. Queued plus Spilled is the depth of
+// the queue.
+type Stats struct {
+	// Queued is the number of entries waiting in memory.
+	Queued int
+	// Spilled is the number of entries waiting on disk, in SpilledBytes.
+	Spilled      int
+	SpilledBytes int64
+	// Sent is the number of entries delivered to the collector.
+	Sent uint64
+	// Failures is the number of failed attempts to send a batch.
+	Failures uint64
+	// Dropped is the number of entries lost because the queue was full, the
+	// collector rejected them, or the writer closed while they could not be
+	// sent nor spilled.
+	Dropped uint64
+}
+
+// Writer is a zapcore.WriteSyncer that ships the entries to a collector in
+// batches from a goroutine. While the collector is unavailable, it retries
+// with an exponential backoff and spills the entries to disk, then sends
+// them in order once the collector is back. Entries may be sent twice if
+// sending fails midway.
+type Writer struct {
+	given  Configuration // as passed to NewWriter
+	cfg    Configuration
+	sender sender
+	disk   *diskQueue // nil without Dir, used by run
+
+	mu           sync.Mutex
+	queue        [][]byte
+	pending      [][]byte // failed batch without disk, sent first
+	spilled      int
+	spilledBytes int64
+	closed       bool
+
+	sent     uint64 // atomic
+	failures uint64 // atomic
+	dropped  uint64 // atomic
+
+	wake  chan struct{}
+	flush chan chan error
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// NewWriter starts shipping to the collector in the background. It opens the
+// queue in Dir, the entries found there are sent first, and fails if another
+// writer has it open. Close stops it.
+func NewWriter(cfg Configuration) (*Writer, error) {
+	given := cfg
+	if cfg.BatchSize < 0 || cfg.BatchInterval < 0 || cfg.MinBackoff < 0 || cfg.MaxBackoff < 0 ||
+		cfg.Timeout < 0 || cfg.QueueSize < 0 || cfg.MaxDiskSize < 0 || cfg.SegmentSize < 0 {
+		return nil, errors.New("logship: negative limits are not allowed")
+	}
+	if cfg.ContentType == "" {
+		cfg.ContentType = DefaultContentType
+	}
+	if cfg.BatchSize == 0 {
+		cfg.BatchSize = DefaultBatchSize
+	}
+	if cfg.BatchInterval == 0 {
+		cfg.BatchInterval = DefaultBatchInterval
+	}
+	if cfg.MinBackoff == 0 {
+		cfg.MinBackoff = DefaultMinBackoff
+	}
+	if cfg.MaxBackoff == 0 {
+		cfg.MaxBackoff = DefaultMaxBackoff
+	}
+	if cfg.MaxBackoff < cfg.MinBackoff {
+		cfg.MaxBackoff = cfg.MinBackoff
+	}
+	if cfg.Timeout == 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	if cfg.QueueSize == 0 {
+		cfg.QueueSize = DefaultQueueSize
+	}
+	if cfg.MaxDiskSize == 0 {
+		cfg.MaxDiskSize = DefaultMaxDiskSize
+	}
+	if cfg.SegmentSize == 0 {
+		cfg.SegmentSize = DefaultSegmentSize
+	}
+	s, err := newSender(cfg)
+	if err != nil {
+		return nil, err
+	}
+	w := &Writer{
+		given:  given,
+		cfg:    cfg,
+		sender: s,
+		wake:   make(chan struct{}, 1),
+		flush:  make(chan chan error),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if cfg.Dir != "" {
+		maxSize, segmentSize := int64(cfg.MaxDiskSize)*megabyte, int64(cfg.SegmentSize)*megabyte
+		if segmentSize > maxSize/4 {
+			segmentSize = maxSize / 4
+		}
+		w.disk, err = openDiskQueue(cfg.Dir, maxSize, segmentSize, w.drop)
+		if err != nil {
+			_ = s.close()
+			return nil, err
+		}
+		w.updateSpilled()
+	}
+	go w.run()
+	return w, nil
+}
+
+// Configuration returns the configuration passed to NewWriter.
+func (w *Writer) Configuration() Configuration {
+	return w.given
+}
+
+// Write implements io.Writer. p is copied and sent later.
+func (w *Writer) Write(p []byte) (int, error) {
+	e := make([]byte, len(p))
+	copy(e, p)
+	w.mu.Lock()
+	if w.closed || len(w.queue) >= w.cfg.QueueSize {
+		w.mu.Unlock()
+		w.drop(1)
+		return len(p), nil
+	}
+	w.queue = append(w.queue, e)
+	full := len(w.queue) >= w.cfg.BatchSize
+	w.mu.Unlock()
+
+	if full {
+		select {
+		case w.wake <- struct{}{}:
+		default:
+		}
+	}
+	return len(p), nil
+}
+
+func (w *Writer) drop(n int) {
+	atomic.AddUint64(&w.dropped, uint64(n))
+}
+
+func (w *Writer) run() {
+	defer close(w.done)
+	ticker := time.NewTicker(w.cfg.BatchInterval)
+	defer ticker.Stop()
+	var retry <-chan time.Time // not nil while the collector is unavailable
+	var backoff time.Duration
+	attempt := func(all bool) {
+		err := w.ship(all)
+		if err == nil {
+			retry, backoff = nil, 0
+			return
+		}
+		if backoff == 0 {
+			_, _ = fmt.Fprintf(os.Stderr, "logship: collector unavailable, retrying [%v]\n", err)
+		}
+		backoff = w.nextBackoff(backoff)
+		retry = time.After(backoff)
+		w.spill()
+	}
+	for {
+		select {
+		case <-w.wake:
+			if retry == nil {
+				attempt(false)
+			} else {
+				w.spill()
+			}
+		case <-ticker.C:
+			if retry == nil {
+				attempt(true)
+			} else {
+				w.spill()
+			}
+		case <-retry:
+			attempt(true)
+		case reply := <-w.flush:
+			if retry == nil {
+				attempt(true)
+			}
+			reply <- w.flushed(retry != nil)
+		case <-w.stop:
+			if retry == nil {
+				attempt(true)
+			}
+			w.spill()
+			w.mu.Lock()
+			w.drop(len(w.pending) + len(w.queue))
+			w.pending, w.queue = nil, nil
+			w.mu.Unlock()
+			if w.disk != nil {
+				if err := w.disk.close(); err != nil {
+					_, _ = fmt.Fprintf(os.Stderr, "logship: %v\n", err)
+				}
+			}
+			return
+		}
+	}
+}
+
+// ship sends the entries in order: the pending batch, the spilled entries,
+// then the queue, in full batches only unless all is true. It stops at the
+// first failure.
+func (w *Writer) ship(all bool) error {
+	w.mu.Lock()
+	pending := w.pending
+	w.mu.Unlock()
+	if pending != nil {
+		if err := w.send(pending); err != nil {
+			return err
+		}
+		w.mu.Lock()
+		w.pending = nil
+		w.mu.Unlock()
+	}
+	for w.disk != nil && w.disk.count > 0 {
+		batch, err := w.disk.peek(w.cfg.BatchSize)
+		if err != nil {
+			_, _ = fmt.Fprintf(os.Stderr, "logship: dropped spilled entries [%v]\n", err)
+			w.updateSpilled()
+			continue
+		}
+		if err := w.send(batch); err != nil {
+			return err
+		}
+		w.disk.commit()
+		w.updateSpilled()
+	}
+	for {
+		w.mu.Lock()
+		n := len(w.queue)
+		if n == 0 || (!all && n < w.cfg.BatchSize) {
+			w.mu.Unlock()
+			return nil
+		}
+		if n > w.cfg.BatchSize {
+			n = w.cfg.BatchSize
+		}
+		batch := w.queue[:n:n]
+		w.queue = w.queue[n:]
+		w.mu.Unlock()
+
+		if err := w.send(batch); err != nil {
+			if w.disk != nil {
+				w.spillBatch(batch)
+			} else {
+				w.mu.Lock()
+				w.pending = batch
+				w.mu.Unlock()
+			}
+			return err
+		}
+	}
+}
+
+// send sends a batch. The batches rejected by the collector are dropped,
+// sending them again would not help.
+func (w *Writer) send(batch [][]byte) error {
+	err := w.sender.send(batch)
+	var rejected *rejectedError
+	switch {
+	case err == nil:
+		atomic.AddUint64(&w.sent, uint64(len(batch)))
+		return nil
+	case errors.As(err, &rejected):
+		_, _ = fmt.Fprintf(os.Stderr, "logship: collector rejected %d entries [%v]\n", len(batch), err)
+		w.drop(len(batch))
+		return nil
+	default:
+		atomic.AddUint64(&w.failures, 1)
+		return err
+	}
+}
+
+func (w *Writer) nextBackoff(backoff time.Duration) time.Duration {
+	backoff *= 2
+	if backoff < w.cfg.MinBackoff {
+		backoff = w.cfg.MinBackoff
+	}
+	if backoff > w.cfg.MaxBackoff {
+		backoff = w.cfg.MaxBackoff
+	}
+	// Up to 20% of jitter, so that the writers don't retry together.
+	return backoff + time.Duration(rand.Int63n(int64(backoff)/5+1))
+}
+
+// spill moves the queue to the disk, if there is one.
+func (w *Writer) spill() {
+	if w.disk == nil {
+		return
+	}
+	w.mu.Lock()
+	queue := w.queue
+	w.queue = nil
+	w.mu.Unlock()
+	if len(queue) > 0 {
+		w.spillBatch(queue)
+	}
+}
+
+func (w *Writer) spillBatch(batch [][]byte) {
+	if err := w.disk.append(batch); err != nil {
+		_, _ = fmt.Fprintf(os.Stderr, "logship: spill failed [%v]\n", err)
+	}
+	w.updateSpilled()
+}
+
+func (w *Writer) updateSpilled() {
+	w.mu.Lock()
+	w.spilled, w.spilledBytes = w.disk.count, w.disk.size
+	w.mu.Unlock()
+}
+
+// flushed returns the result of Sync: the entries are sent, or spilled and
+// synced to disk.
+func (w *Writer) flushed(unavailable bool) error {
+	if !unavailable {
+		return nil
+	}
+	if w.disk == nil {
+		return errUnavailable
+	}
+	return w.disk.sync()
+}
+
+var errUnavailable = errors.New("logship: collector unavailable, entries kept in memory")
+
+// Sync sends the queued entries. If the collector is unavailable, it writes
+// them to disk instead, or fails without Dir.
+func (w *Writer) Sync() error {
+	reply := make(chan error, 1)
+	select {
+	case w.flush <- reply:
+		return <-reply
+	case <-w.done:
+		return nil
+	}
+}
+
+// Close sends the queued entries, or spills them to disk if the collector
+// is unavailable, and stops the writer. The entries written afterwards are
+// dropped.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	if w.closed {
+		w.mu.Unlock()
+		return nil
+	}
+	w.closed = true
+	w.mu.Unlock()
+
+	close(w.stop)
+	<-w.done
+	return w.sender.close()
+}
+
+// Stats returns the counters of the writer.
+func (w *Writer) Stats() Stats {
+	w.mu.Lock()
+	stats := Stats{
+		Queued:       len(w.queue) + len(w.pending),
+		Spilled:      w.spilled,
+		SpilledBytes: w.spilledBytes,
+	}
+	w.mu.Unlock()
+	stats.Sent = atomic.LoadUint64(&w.sent)
+	stats.Failures = atomic.LoadUint64(&w.failures)
+	stats.Dropped = atomic.LoadUint64(&w.dropped)
+	return stats
+}
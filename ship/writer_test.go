@@ -0,0 +1,297 @@
+package logship
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// collector is a stand-in HTTP collector, unavailable while down.
+type collector struct {
+	*httptest.Server
+	mu       sync.Mutex
+	down     bool
+	status   int // of the requests while down
+	lines    []string
+	batches  []int
+	requests int
+	header   http.Header
+}
+
+func newCollector(t *testing.T) *collector {
+	t.Helper()
+	c := &collector{status: http.StatusServiceUnavailable}
+	c.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.requests++
+		if c.down {
+			w.WriteHeader(c.status)
+			return
+		}
+		c.header = r.Header
+		lines := strings.SplitAfter(string(body), "\n")
+		if lines[len(lines)-1] == "" {
+			lines = lines[:len(lines)-1]
+		}
+		c.lines = append(c.lines, lines...)
+		c.batches = append(c.batches, len(lines))
+	}))
+	t.Cleanup(c.Close)
+	return c
+}
+
+func (c *collector) setDown(down bool) {
+	c.mu.Lock()
+	c.down = down
+	c.mu.Unlock()
+}
+
+func (c *collector) received() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.lines...)
+}
+
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "logship")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.RemoveAll(dir) })
+	return dir
+}
+
+func newTestWriter(t *testing.T, cfg Configuration) *Writer {
+	t.Helper()
+	if cfg.MinBackoff == 0 {
+		cfg.MinBackoff, cfg.MaxBackoff = 10*time.Millisecond, 20*time.Millisecond
+	}
+	if cfg.BatchInterval == 0 {
+		cfg.BatchInterval = 10 * time.Millisecond
+	}
+	w, err := NewWriter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = w.Close() })
+	return w
+}
+
+func entry(i int) string {
+	return fmt.Sprintf("entry %03d\n", i)
+}
+
+func writeEntries(t *testing.T, w *Writer, from, to int) {
+	t.Helper()
+	for i := from; i < to; i++ {
+		if _, err := w.Write([]byte(entry(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func checkOrder(t *testing.T, got []string, from, to int) {
+	t.Helper()
+	if len(got) != to-from {
+		t.Fatalf("got %d entries, want %d", len(got), to-from)
+	}
+	for i, line := range got {
+		if want := entry(from + i); line != want {
+			t.Fatalf("entry %d is %q, want %q", i, line, want)
+		}
+	}
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestWriterHTTPBatches(t *testing.T) {
+	c := newCollector(t)
+	w := newTestWriter(t, Configuration{
+		URL:           c.URL,
+		BatchSize:     3,
+		BatchInterval: time.Hour,
+		Headers:       map[string]string{"Authorization": "Bearer token"},
+	})
+	writeEntries(t, w, 0, 7)
+	if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	checkOrder(t, c.received(), 0, 7)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if fmt.Sprint(c.batches) != "[3 3 1]" {
+		t.Errorf("got batches of %v entries, want [3 3 1]", c.batches)
+	}
+	if got := c.header.Get("Content-Type"); got != DefaultContentType {
+		t.Errorf("got content type %q", got)
+	}
+	if got := c.header.Get("Authorization"); got != "Bearer token" {
+		t.Errorf("got authorization %q", got)
+	}
+	if got := w.Stats(); got.Sent != 7 || got.Queued != 0 || got.Dropped != 0 {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestWriterSpillAndReplay(t *testing.T) {
+	c := newCollector(t)
+	c.setDown(true)
+	w := newTestWriter(t, Configuration{URL: c.URL, BatchSize: 16, Dir: tempDir(t)})
+
+	writeEntries(t, w, 0, 100)
+	// Sync spills to disk while the collector is down.
+	if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	writeEntries(t, w, 100, 200)
+	waitFor(t, "the entries to be spilled", func() bool { return w.Stats().Spilled == 200 })
+	if got := w.Stats(); got.Queued != 0 || got.SpilledBytes == 0 || got.Failures == 0 {
+		t.Errorf("got %+v while down", got)
+	}
+
+	c.setDown(false)
+	waitFor(t, "the entries to be sent", func() bool { return w.Stats().Sent == 200 })
+	checkOrder(t, c.received(), 0, 200)
+	if got := w.Stats(); got.Spilled != 0 || got.SpilledBytes != 0 || got.Dropped != 0 {
+		t.Errorf("got %+v once sent", got)
+	}
+}
+
+func TestWriterReplayNextRun(t *testing.T) {
+	c := newCollector(t)
+	c.setDown(true)
+	dir := tempDir(t)
+	cfg := Configuration{URL: c.URL, Dir: dir}
+	w := newTestWriter(t, cfg)
+	writeEntries(t, w, 0, 50)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.Stats(); got.Spilled != 50 || got.Dropped != 0 {
+		t.Fatalf("got %+v after Close", got)
+	}
+
+	c.setDown(false)
+	w = newTestWriter(t, cfg)
+	writeEntries(t, w, 50, 60)
+	waitFor(t, "the entries to be sent", func() bool { return w.Stats().Sent == 60 })
+	checkOrder(t, c.received(), 0, 60)
+}
+
+func TestWriterLocksDir(t *testing.T) {
+	c := newCollector(t)
+	cfg := Configuration{URL: c.URL, Dir: tempDir(t)}
+	w := newTestWriter(t, cfg)
+	if w2, err := NewWriter(cfg); err == nil {
+		_ = w2.Close()
+		t.Fatal("second writer opened the directory")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	newTestWriter(t, cfg)
+}
+
+func TestWriterMemoryRetry(t *testing.T) {
+	c := newCollector(t)
+	c.setDown(true)
+	c.status = http.StatusTooManyRequests
+	w := newTestWriter(t, Configuration{URL: c.URL, BatchSize: 4})
+	writeEntries(t, w, 0, 10)
+	if err := w.Sync(); err != errUnavailable {
+		t.Errorf("got %v from Sync, want %v", err, errUnavailable)
+	}
+	c.setDown(false)
+	waitFor(t, "the entries to be sent", func() bool { return w.Stats().Sent == 10 })
+	checkOrder(t, c.received(), 0, 10)
+}
+
+func TestWriterRejected(t *testing.T) {
+	c := newCollector(t)
+	c.setDown(true)
+	c.status = http.StatusBadRequest
+	w := newTestWriter(t, Configuration{URL: c.URL, BatchSize: 5, Dir: tempDir(t)})
+	writeEntries(t, w, 0, 5)
+	if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if got := w.Stats(); got.Dropped != 5 || got.Sent != 0 || got.Spilled != 0 {
+		t.Errorf("got %+v", got)
+	}
+	time.Sleep(50 * time.Millisecond)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.requests != 1 {
+		t.Errorf("rejected batch sent %d times", c.requests)
+	}
+}
+
+func TestWriterTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	lines := make(chan string, 100)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			lines <- line
+		}
+	}()
+
+	w := newTestWriter(t, Configuration{URL: "tcp://" + ln.Addr().String(), BatchSize: 8})
+	writeEntries(t, w, 0, 20)
+	if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for len(got) < 20 {
+		select {
+		case line := <-lines:
+			got = append(got, line)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("got %d entries", len(got))
+		}
+	}
+	checkOrder(t, got, 0, 20)
+}
+
+func TestWriterQueueFull(t *testing.T) {
+	c := newCollector(t)
+	c.setDown(true)
+	w := newTestWriter(t, Configuration{URL: c.URL, QueueSize: 10, BatchSize: 100, BatchInterval: time.Hour})
+	writeEntries(t, w, 0, 15)
+	if got := w.Stats(); got.Queued != 10 || got.Dropped != 5 {
+		t.Errorf("got %+v", got)
+	}
+}
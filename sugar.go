@@ -0,0 +1,293 @@
+package log
+
+import (
+	"context"
+	"fmt"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// The sugared functions below log with the global logger like Info and
+// InfoCtx, formatting the message with fmt.Sprintf for the *f variants, or
+// taking loosely typed key-value pairs for the *w variants as
+// zap.SugaredLogger does:
+//
+//	log.Infof("user %s signed in", id)
+//	log.Infow("signed in", "user", id, "attempts", 3)
+//	log.InfoCtxw(ctx, "signed in", "user", id)
+//
+// The format strings are checked by go vet.
+
+// sugared returns the sugared logger of ctx, nil if lvl is disabled so that
+// the message is not formatted for nothing. ctx may be nil.
+func sugared(ctx context.Context, lvl zapcore.Level) *zap.SugaredLogger {
+	g := global()
+	logger := g.skipped
+	var fields []zap.Field
+	if ctx != nil {
+		if c := fromContext(ctx); c != nil {
+			if c.logger != nil {
+				logger = c.logger.WithOptions(zap.AddCallerSkip(1))
+			}
+			fields = c.fields
+		}
+	}
+	// Panic and fatal entries are logged by zap even if their level is
+	// disabled.
+	if lvl < zapcore.DPanicLevel && !logger.Core().Enabled(lvl) {
+		return nil
+	}
+	if ctx != nil {
+		fields = mergeFields(fields, contextFields(ctx))
+	}
+	if logger == g.skipped && len(fields) == 0 {
+		return g.sugared
+	}
+	return logger.With(fields...).Sugar()
+}
+
+// Debugf formats a message with fmt.Sprintf and logs it at DebugLevel.
+func Debugf(template string, args ...interface{}) {
+	if s := sugared(nil, zapcore.DebugLevel); s != nil {
+		s.Debug(fmt.Sprintf(template, args...))
+	}
+}
+
+// Debugw logs a message at DebugLevel with loosely typed key-value pairs, see
+// zap.SugaredLogger.Debugw.
+func Debugw(msg string, keysAndValues ...interface{}) {
+	if s := sugared(nil, zapcore.DebugLevel); s != nil {
+		s.Debugw(msg, keysAndValues...)
+	}
+}
+
+// DebugCtxf formats a message with fmt.Sprintf and logs it at DebugLevel with
+// the logger, fields and trace of ctx, see L.
+func DebugCtxf(ctx context.Context, template string, args ...interface{}) {
+	if s := sugared(ctx, zapcore.DebugLevel); s != nil {
+		s.Debug(fmt.Sprintf(template, args...))
+	}
+}
+
+// DebugCtxw logs a message at DebugLevel with loosely typed key-value pairs and
+// the logger, fields and trace of ctx, see L.
+func DebugCtxw(ctx context.Context, msg string, keysAndValues ...interface{}) {
+	if s := sugared(ctx, zapcore.DebugLevel); s != nil {
+		s.Debugw(msg, keysAndValues...)
+	}
+}
+
+// Infof formats a message with fmt.Sprintf and logs it at InfoLevel.
+func Infof(template string, args ...interface{}) {
+	if s := sugared(nil, zapcore.InfoLevel); s != nil {
+		s.Info(fmt.Sprintf(template, args...))
+	}
+}
+
+// Infow logs a message at InfoLevel with loosely typed key-value pairs, see
+// zap.SugaredLogger.Infow.
+func Infow(msg string, keysAndValues ...interface{}) {
+	if s := sugared(nil, zapcore.InfoLevel); s != nil {
+		s.Infow(msg, keysAndValues...)
+	}
+}
+
+// InfoCtxf formats a message with fmt.Sprintf and logs it at InfoLevel with
+// the logger, fields and trace of ctx, see L.
+func InfoCtxf(ctx context.Context, template string, args ...interface{}) {
+	if s := sugared(ctx, zapcore.InfoLevel); s != nil {
+		s.Info(fmt.Sprintf(template, args...))
+	}
+}
+
+// InfoCtxw logs a message at InfoLevel with loosely typed key-value pairs and
+// the logger, fields and trace of ctx, see L.
+func InfoCtxw(ctx context.Context, msg string, keysAndValues ...interface{}) {
+	if s := sugared(ctx, zapcore.InfoLevel); s != nil {
+		s.Infow(msg, keysAndValues...)
+	}
+}
+
+// Warnf formats a message with fmt.Sprintf and logs it at WarnLevel.
+func Warnf(template string, args ...interface{}) {
+	if s := sugared(nil, zapcore.WarnLevel); s != nil {
+		s.Warn(fmt.Sprintf(template, args...))
+	}
+}
+
+// Warnw logs a message at WarnLevel with loosely typed key-value pairs, see
+// zap.SugaredLogger.Warnw.
+func Warnw(msg string, keysAndValues ...interface{}) {
+	if s := sugared(nil, zapcore.WarnLevel); s != nil {
+		s.Warnw(msg, keysAndValues...)
+	}
+}
+
+// WarnCtxf formats a message with fmt.Sprintf and logs it at WarnLevel with
+// the logger, fields and trace of ctx, see L.
+func WarnCtxf(ctx context.Context, template string, args ...interface{}) {
+	if s := sugared(ctx, zapcore.WarnLevel); s != nil {
+		s.Warn(fmt.Sprintf(template, args...))
+	}
+}
+
+// WarnCtxw logs a message at WarnLevel with loosely typed key-value pairs and
+// the logger, fields and trace of ctx, see L.
+func WarnCtxw(ctx context.Context, msg string, keysAndValues ...interface{}) {
+	if s := sugared(ctx, zapcore.WarnLevel); s != nil {
+		s.Warnw(msg, keysAndValues...)
+	}
+}
+
+// Errorf formats a message with fmt.Sprintf and logs it at ErrorLevel.
+func Errorf(template string, args ...interface{}) {
+	if s := sugared(nil, zapcore.ErrorLevel); s != nil {
+		s.Error(fmt.Sprintf(template, args...))
+	}
+}
+
+// Errorw logs a message at ErrorLevel with loosely typed key-value pairs, see
+// zap.SugaredLogger.Errorw.
+func Errorw(msg string, keysAndValues ...interface{}) {
+	if s := sugared(nil, zapcore.ErrorLevel); s != nil {
+		s.Errorw(msg, keysAndValues...)
+	}
+}
+
+// ErrorCtxf formats a message with fmt.Sprintf and logs it at ErrorLevel with
+// the logger, fields and trace of ctx, see L.
+func ErrorCtxf(ctx context.Context, template string, args ...interface{}) {
+	if s := sugared(ctx, zapcore.ErrorLevel); s != nil {
+		s.Error(fmt.Sprintf(template, args...))
+	}
+}
+
+// ErrorCtxw logs a message at ErrorLevel with loosely typed key-value pairs and
+// the logger, fields and trace of ctx, see L.
+func ErrorCtxw(ctx context.Context, msg string, keysAndValues ...interface{}) {
+	if s := sugared(ctx, zapcore.ErrorLevel); s != nil {
+		s.Errorw(msg, keysAndValues...)
+	}
+}
+
+// DPanicf formats a message with fmt.Sprintf and logs it at DPanicLevel.
+//
+// If the logger is in development mode, it then panics.
+func DPanicf(template string, args ...interface{}) {
+	if s := sugared(nil, zapcore.DPanicLevel); s != nil {
+		s.DPanic(fmt.Sprintf(template, args...))
+	}
+}
+
+// DPanicw logs a message at DPanicLevel with loosely typed key-value pairs,
+// see zap.SugaredLogger.DPanicw.
+//
+// If the logger is in development mode, it then panics.
+func DPanicw(msg string, keysAndValues ...interface{}) {
+	if s := sugared(nil, zapcore.DPanicLevel); s != nil {
+		s.DPanicw(msg, keysAndValues...)
+	}
+}
+
+// DPanicCtxf formats a message with fmt.Sprintf and logs it at DPanicLevel with
+// the logger, fields and trace of ctx, see L.
+//
+// If the logger is in development mode, it then panics.
+func DPanicCtxf(ctx context.Context, template string, args ...interface{}) {
+	if s := sugared(ctx, zapcore.DPanicLevel); s != nil {
+		s.DPanic(fmt.Sprintf(template, args...))
+	}
+}
+
+// DPanicCtxw logs a message at DPanicLevel with loosely typed key-value pairs
+// and the logger, fields and trace of ctx, see L.
+//
+// If the logger is in development mode, it then panics.
+func DPanicCtxw(ctx context.Context, msg string, keysAndValues ...interface{}) {
+	if s := sugared(ctx, zapcore.DPanicLevel); s != nil {
+		s.DPanicw(msg, keysAndValues...)
+	}
+}
+
+// Panicf formats a message with fmt.Sprintf and logs it at PanicLevel.
+//
+// The logger then panics, even if logging at PanicLevel is disabled.
+func Panicf(template string, args ...interface{}) {
+	if s := sugared(nil, zapcore.PanicLevel); s != nil {
+		s.Panic(fmt.Sprintf(template, args...))
+	}
+}
+
+// Panicw logs a message at PanicLevel with loosely typed key-value pairs, see
+// zap.SugaredLogger.Panicw.
+//
+// The logger then panics, even if logging at PanicLevel is disabled.
+func Panicw(msg string, keysAndValues ...interface{}) {
+	if s := sugared(nil, zapcore.PanicLevel); s != nil {
+		s.Panicw(msg, keysAndValues...)
+	}
+}
+
+// PanicCtxf formats a message with fmt.Sprintf and logs it at PanicLevel with
+// the logger, fields and trace of ctx, see L.
+//
+// The logger then panics, even if logging at PanicLevel is disabled.
+func PanicCtxf(ctx context.Context, template string, args ...interface{}) {
+	if s := sugared(ctx, zapcore.PanicLevel); s != nil {
+		s.Panic(fmt.Sprintf(template, args...))
+	}
+}
+
+// PanicCtxw logs a message at PanicLevel with loosely typed key-value pairs and
+// the logger, fields and trace of ctx, see L.
+//
+// The logger then panics, even if logging at PanicLevel is disabled.
+func PanicCtxw(ctx context.Context, msg string, keysAndValues ...interface{}) {
+	if s := sugared(ctx, zapcore.PanicLevel); s != nil {
+		s.Panicw(msg, keysAndValues...)
+	}
+}
+
+// Fatalf formats a message with fmt.Sprintf and logs it at FatalLevel.
+//
+// The logger then calls os.Exit(1), even if logging at FatalLevel is
+// disabled.
+func Fatalf(template string, args ...interface{}) {
+	if s := sugared(nil, zapcore.FatalLevel); s != nil {
+		s.Fatal(fmt.Sprintf(template, args...))
+	}
+}
+
+// Fatalw logs a message at FatalLevel with loosely typed key-value pairs, see
+// zap.SugaredLogger.Fatalw.
+//
+// The logger then calls os.Exit(1), even if logging at FatalLevel is
+// disabled.
+func Fatalw(msg string, keysAndValues ...interface{}) {
+	if s := sugared(nil, zapcore.FatalLevel); s != nil {
+		s.Fatalw(msg, keysAndValues...)
+	}
+}
+
+// FatalCtxf formats a message with fmt.Sprintf and logs it at FatalLevel with
+// the logger, fields and trace of ctx, see L.
+//
+// The logger then calls os.Exit(1), even if logging at FatalLevel is
+// disabled.
+func FatalCtxf(ctx context.Context, template string, args ...interface{}) {
+	if s := sugared(ctx, zapcore.FatalLevel); s != nil {
+		s.Fatal(fmt.Sprintf(template, args...))
+	}
+}
+
+// FatalCtxw logs a message at FatalLevel with loosely typed key-value pairs and
+// the logger, fields and trace of ctx, see L.
+//
+// The logger then calls os.Exit(1), even if logging at FatalLevel is
+// disabled.
+func FatalCtxw(ctx context.Context, msg string, keysAndValues ...interface{}) {
+	if s := sugared(ctx, zapcore.FatalLevel); s != nil {
+		s.Fatalw(msg, keysAndValues...)
+	}
+}
@@ -0,0 +1,67 @@
+package logsyslog
+
+import (
+	logasync "github.com/liasece/log/async"
+	"go.uber.org/zap/zapcore"
+)
+
+// NewCore creates a zap core that sends the entries to w as syslog messages,
+// with the severity matching their level. The messages are sent on the
+// calling goroutine, see NewAsyncCore.
+func NewCore(w *Writer, enab zapcore.LevelEnabler) zapcore.Core {
+	return &core{LevelEnabler: enab, w: w}
+}
+
+// NewAsyncCore creates a core like NewCore that sends the messages through
+// aw, a logasync.Writer writing to w, so that an unreachable daemon doesn't
+// block the callers. The entries above ErrorLevel are sent before Write
+// returns, as the program may be about to exit.
+func NewAsyncCore(w *Writer, aw *logasync.Writer, enab zapcore.LevelEnabler) zapcore.Core {
+	return &core{LevelEnabler: enab, w: w, async: aw}
+}
+
+type core struct {
+	zapcore.LevelEnabler
+	w     *Writer
+	async *logasync.Writer
+	// fields are the fields added by With, formatted with every entry.
+	fields []zapcore.Field
+}
+
+func (c *core) With(fields []zapcore.Field) zapcore.Core {
+	clone := *c
+	clone.fields = append(c.fields[:len(c.fields):len(c.fields)], fields...)
+	return &clone
+}
+
+func (c *core) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+func (c *core) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	all := c.fields
+	if len(fields) > 0 {
+		all = append(all[:len(all):len(all)], fields...)
+	}
+	buf := c.w.format(ent, all)
+	defer buf.Free()
+	if c.async == nil {
+		return c.w.write(buf.Bytes())
+	}
+	_, _ = c.async.WriteLevel(ent.Level, buf.Bytes())
+	if ent.Level > zapcore.ErrorLevel {
+		return c.async.Sync()
+	}
+	return nil
+}
+
+// Sync waits for the messages buffered by an asynchronous core to be sent.
+func (c *core) Sync() error {
+	if c.async == nil {
+		return nil
+	}
+	return c.async.Sync()
+}
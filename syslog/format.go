@@ -0,0 +1,207 @@
+package logsyslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	rfc5424Time = "2006-01-02T15:04:05.000000Z07:00"
+	nilValue    = "-"
+)
+
+var _pool = buffer.NewPool()
+
+// param is a field flattened to a name and a string value.
+type param struct {
+	name  string
+	value string
+}
+
+// format returns the message of an entry, without framing.
+func (w *Writer) format(ent zapcore.Entry, fields []zapcore.Field) *buffer.Buffer {
+	params := flattenFields(fields)
+	if ent.Caller.Defined {
+		params = append(params, param{"caller", ent.Caller.TrimmedPath()})
+	}
+	if ent.Stack != "" {
+		params = append(params, param{"stacktrace", ent.Stack})
+	}
+
+	buf := _pool.Get()
+	buf.AppendByte('<')
+	buf.AppendInt(int64(int(w.cfg.Facility)*8 + syslogSeverity(ent.Level)))
+	buf.AppendByte('>')
+	if w.cfg.Format == RFC3164 {
+		w.appendRFC3164(buf, ent, params)
+	} else {
+		w.appendRFC5424(buf, ent, params)
+	}
+	return buf
+}
+
+// appendRFC5424 appends the message after the PRI, the fields as one
+// structured data element.
+func (w *Writer) appendRFC5424(buf *buffer.Buffer, ent zapcore.Entry, params []param) {
+	buf.AppendString("1 ")
+	buf.AppendString(ent.Time.Format(rfc5424Time))
+	buf.AppendByte(' ')
+	buf.AppendString(headerField(w.cfg.Hostname, 255))
+	buf.AppendByte(' ')
+	buf.AppendString(headerField(w.cfg.AppName, 48))
+	buf.AppendByte(' ')
+	buf.AppendInt(int64(w.pid))
+	buf.AppendByte(' ')
+	buf.AppendString(headerField(ent.LoggerName, 32))
+	buf.AppendByte(' ')
+	if len(params) == 0 {
+		buf.AppendString(nilValue)
+	} else {
+		buf.AppendByte('[')
+		buf.AppendString(w.cfg.StructuredDataID)
+		for _, p := range params {
+			buf.AppendByte(' ')
+			buf.AppendString(sdName(p.name))
+			buf.AppendString(`="`)
+			appendSDValue(buf, p.value)
+			buf.AppendByte('"')
+		}
+		buf.AppendByte(']')
+	}
+	if ent.Message != "" {
+		buf.AppendByte(' ')
+		buf.AppendString(ent.Message)
+	}
+}
+
+// appendRFC3164 appends the message after the PRI, the fields as key=value
+// pairs after the message. The host name is left to the local daemon.
+func (w *Writer) appendRFC3164(buf *buffer.Buffer, ent zapcore.Entry, params []param) {
+	buf.AppendString(ent.Time.Format(time.Stamp))
+	buf.AppendByte(' ')
+	if !w.local {
+		buf.AppendString(headerField(w.cfg.Hostname, 255))
+		buf.AppendByte(' ')
+	}
+	buf.AppendString(headerField(w.cfg.AppName, 32))
+	buf.AppendByte('[')
+	buf.AppendInt(int64(w.pid))
+	buf.AppendString("]: ")
+	buf.AppendString(ent.Message)
+	for _, p := range params {
+		buf.AppendByte(' ')
+		buf.AppendString(p.name)
+		buf.AppendByte('=')
+		if p.value == "" || strings.ContainsAny(p.value, " =\"\\") || !strconv.CanBackquote(p.value) {
+			buf.AppendString(strconv.Quote(p.value))
+		} else {
+			buf.AppendString(p.value)
+		}
+	}
+}
+
+// flattenFields returns the fields in order, the keys of objects and
+// namespaces joined with dots.
+func flattenFields(fields []zapcore.Field) []param {
+	var params []param
+	prefix := ""
+	for _, f := range fields {
+		switch f.Type {
+		case zapcore.SkipType:
+			continue
+		case zapcore.NamespaceType:
+			prefix += f.Key + "."
+			continue
+		}
+		enc := zapcore.NewMapObjectEncoder()
+		f.AddTo(enc)
+		params = appendParams(params, prefix, enc.Fields)
+	}
+	return params
+}
+
+func appendParams(params []param, prefix string, m map[string]interface{}) []param {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		switch v := m[k].(type) {
+		case map[string]interface{}:
+			params = appendParams(params, prefix+k+".", v)
+		default:
+			params = append(params, param{prefix + k, paramValue(v)})
+		}
+	}
+	return params
+}
+
+// paramValue returns the basic values as fmt prints them, the others as
+// JSON.
+func paramValue(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case time.Time:
+		return v.Format(time.RFC3339Nano)
+	case time.Duration:
+		return v.String()
+	case bool, int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64,
+		uintptr, float32, float64, complex64, complex128:
+		return fmt.Sprint(v)
+	}
+	if b, err := json.Marshal(v); err == nil {
+		return string(b)
+	}
+	return fmt.Sprint(v)
+}
+
+// headerField returns s restricted to the printable US-ASCII characters
+// allowed in header fields, and at most max of them, "-" if empty.
+func headerField(s string, max int) string {
+	if s == "" {
+		return nilValue
+	}
+	return printable(s, max, "")
+}
+
+// sdName returns s as a valid PARAM-NAME.
+func sdName(s string) string {
+	if s == "" {
+		return "_"
+	}
+	return printable(s, 32, `= ]"`)
+}
+
+func printable(s string, max int, forbidden string) string {
+	var b strings.Builder
+	for i := 0; i < len(s) && b.Len() < max; i++ {
+		c := s[i]
+		if c < 33 || c > 126 || strings.IndexByte(forbidden, c) >= 0 {
+			c = '_'
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// appendSDValue escapes the characters of s that end a PARAM-VALUE.
+func appendSDValue(buf *buffer.Buffer, s string) {
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"', '\\', ']':
+			buf.AppendByte('\\')
+			buf.AppendByte(c)
+		default:
+			buf.AppendByte(c)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package logsyslog
+
+import (
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap/zapcore"
+)
+
+// Facility is the syslog facility of the messages.
+type Facility int
+
+// Facilities, see RFC 5424.
+const (
+	FacilityKern Facility = iota
+	FacilityUser
+	FacilityMail
+	FacilityDaemon
+	FacilityAuth
+	FacilitySyslog
+	FacilityLPR
+	FacilityNews
+	FacilityUUCP
+	FacilityCron
+	FacilityAuthPriv
+	FacilityFTP
+	FacilityNTP
+	FacilitySecurity
+	FacilityConsole
+	FacilitySolarisCron
+	FacilityLocal0
+	FacilityLocal1
+	FacilityLocal2
+	FacilityLocal3
+	FacilityLocal4
+	FacilityLocal5
+	FacilityLocal6
+	FacilityLocal7
+)
+
+var facilityNames = []string{
+	"kern", "user", "mail", "daemon", "auth", "syslog", "lpr", "news",
+	"uucp", "cron", "authpriv", "ftp", "ntp", "security", "console", "solaris-cron",
+	"local0", "local1", "local2", "local3", "local4", "local5", "local6", "local7",
+}
+
+func (f Facility) String() string {
+	if f >= 0 && int(f) < len(facilityNames) {
+		return facilityNames[f]
+	}
+	return fmt.Sprintf("Facility(%d)", int(f))
+}
+
+// UnmarshalText unmarshals the name of a facility, such as "local0".
+func (f *Facility) UnmarshalText(text []byte) error {
+	name := strings.ToLower(string(text))
+	for i, n := range facilityNames {
+		if n == name {
+			*f = Facility(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unrecognized facility: %q", text)
+}
+
+// Severities, see RFC 5424.
+const (
+	severityEmergency = iota
+	severityAlert
+	severityCritical
+	severityError
+	severityWarning
+	severityNotice
+	severityInfo
+	severityDebug
+)
+
+func syslogSeverity(lvl zapcore.Level) int {
+	switch lvl {
+	case zapcore.DebugLevel:
+		return severityDebug
+	case zapcore.InfoLevel:
+		return severityInfo
+	case zapcore.WarnLevel:
+		return severityWarning
+	case zapcore.ErrorLevel:
+		return severityError
+	case zapcore.DPanicLevel:
+		return severityCritical
+	case zapcore.PanicLevel:
+		return severityCritical
+	case zapcore.FatalLevel:
+		return severityCritical
+	default:
+		// Unrecognized levels are critical.
+		return severityCritical
+	}
+}
@@ -0,0 +1,333 @@
+package logsyslog
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Format is the syslog message format.
+type Format int
+
+const (
+	// RFC5424 messages carry the fields as structured data.
+	RFC5424 Format = iota
+	// RFC3164 messages are the BSD syslog format, the fields are appended to
+	// the message.
+	RFC3164
+)
+
+var formatNames = []string{"rfc5424", "rfc3164"}
+
+func (f Format) String() string {
+	if f >= 0 && int(f) < len(formatNames) {
+		return formatNames[f]
+	}
+	return fmt.Sprintf("Format(%d)", int(f))
+}
+
+// UnmarshalText unmarshals the name of a format, such as "rfc3164".
+func (f *Format) UnmarshalText(text []byte) error {
+	name := strings.ToLower(string(text))
+	for i, n := range formatNames {
+		if n == name {
+			*f = Format(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unrecognized format: %q", text)
+}
+
+// Framing is how messages are delimited on stream connections, see RFC 6587.
+// Datagrams carry one message each.
+type Framing int
+
+const (
+	// FramingAuto uses octet counting over TCP and newlines over Unix
+	// sockets, as rsyslog expects.
+	FramingAuto Framing = iota
+	// FramingOctetCounting prefixes messages with their length.
+	FramingOctetCounting
+	// FramingNewline terminates messages with a newline. The newlines
+	// within messages are written as #012, as rsyslog escapes them.
+	FramingNewline
+)
+
+var framingNames = []string{"auto", "octet_counting", "newline"}
+
+func (f Framing) String() string {
+	if f >= 0 && int(f) < len(framingNames) {
+		return framingNames[f]
+	}
+	return fmt.Sprintf("Framing(%d)", int(f))
+}
+
+// UnmarshalText unmarshals the name of a framing, such as "octet_counting".
+func (f *Framing) UnmarshalText(text []byte) error {
+	name := strings.ToLower(string(text))
+	for i, n := range framingNames {
+		if n == name {
+			*f = Framing(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unrecognized framing: %q", text)
+}
+
+// Configuration is the set of parameters of a syslog connection.
+type Configuration struct {
+	// Network is "udp", "tcp", "unix" or "unixgram". If Network and Address
+	// are empty, the local syslog daemon is used.
+	Network string
+	Address string
+	Format  Format
+	Framing Framing
+	// Facility is FacilityUser if zero, the kernel facility can't be used.
+	Facility Facility
+	// AppName is the name of the executable if empty.
+	AppName string
+	// Hostname is os.Hostname if empty.
+	Hostname string
+	// StructuredDataID is the SD-ID of the fields in RFC 5424 messages,
+	// DefaultStructuredDataID if empty.
+	StructuredDataID string
+	// Timeout bounds connecting and writing, DefaultTimeout if zero.
+	Timeout time.Duration
+	// MinBackoff and MaxBackoff bound the delay before connecting again
+	// after a failure, which doubles with every failure. Messages written
+	// meanwhile fail right away. DefaultMinBackoff and DefaultMaxBackoff if
+	// zero.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+const (
+	// DefaultStructuredDataID uses the private enterprise number reserved
+	// for documentation.
+	DefaultStructuredDataID = "fields@32473"
+	// DefaultTimeout is the default timeout to connect and write.
+	DefaultTimeout = 5 * time.Second
+	// DefaultMinBackoff is the first delay before connecting again by
+	// default.
+	DefaultMinBackoff = 500 * time.Millisecond
+	// DefaultMaxBackoff is the longest delay before connecting again by
+	// default.
+	DefaultMaxBackoff = time.Minute
+)
+
+// localPaths are the usual sockets of the local syslog daemon.
+var localPaths = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// Writer sends messages to a syslog daemon, reconnecting when the
+// connection fails.
+type Writer struct {
+	cfg   Configuration
+	local bool
+	pid   int
+
+	mu      sync.Mutex
+	conn    net.Conn
+	network string        // of conn
+	dialing chan struct{} // closed once the connection being dialed is ready
+	backoff time.Duration
+	retryAt time.Time // no connection is dialed before
+	err     error     // of the last dial
+}
+
+// NewWriter returns a writer to the configured syslog daemon, which it
+// connects to on the first write.
+func NewWriter(cfg Configuration) (*Writer, error) {
+	if (cfg.Network == "") != (cfg.Address == "") {
+		return nil, errors.New("logsyslog: network and address go together")
+	}
+	switch cfg.Network {
+	case "", "udp", "udp4", "udp6", "tcp", "tcp4", "tcp6", "unix", "unixgram":
+	default:
+		return nil, fmt.Errorf("logsyslog: unsupported network %q", cfg.Network)
+	}
+	if cfg.Format != RFC5424 && cfg.Format != RFC3164 {
+		return nil, fmt.Errorf("logsyslog: unknown format %v", cfg.Format)
+	}
+	if cfg.Facility < 0 || cfg.Facility > FacilityLocal7 {
+		return nil, fmt.Errorf("logsyslog: unknown facility %v", cfg.Facility)
+	}
+	if cfg.Facility == FacilityKern {
+		cfg.Facility = FacilityUser
+	}
+	if cfg.AppName == "" {
+		cfg.AppName = filepath.Base(os.Args[0])
+	}
+	if cfg.Hostname == "" {
+		cfg.Hostname, _ = os.Hostname()
+	}
+	if cfg.StructuredDataID == "" {
+		cfg.StructuredDataID = DefaultStructuredDataID
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	if cfg.MinBackoff <= 0 {
+		cfg.MinBackoff = DefaultMinBackoff
+	}
+	if cfg.MaxBackoff <= 0 {
+		cfg.MaxBackoff = DefaultMaxBackoff
+	}
+	if cfg.MaxBackoff < cfg.MinBackoff {
+		cfg.MaxBackoff = cfg.MinBackoff
+	}
+	return &Writer{
+		cfg:   cfg,
+		local: cfg.Network == "" || strings.HasPrefix(cfg.Network, "unix"),
+		pid:   os.Getpid(),
+	}, nil
+}
+
+// connect makes sure there is a connection. Only one goroutine dials, the
+// others wait for it; after a failure, connect fails right away until the
+// backoff expires.
+func (w *Writer) connect() error {
+	w.mu.Lock()
+	for w.dialing != nil {
+		dialing := w.dialing
+		w.mu.Unlock()
+		<-dialing
+		w.mu.Lock()
+	}
+	if w.conn != nil {
+		w.mu.Unlock()
+		return nil
+	}
+	if time.Now().Before(w.retryAt) {
+		err := w.err
+		w.mu.Unlock()
+		return err
+	}
+	dialing := make(chan struct{})
+	w.dialing = dialing
+	w.mu.Unlock()
+
+	conn, network, err := w.dial()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.dialing = nil
+	close(dialing)
+	if err != nil {
+		w.backoff *= 2
+		if w.backoff < w.cfg.MinBackoff {
+			w.backoff = w.cfg.MinBackoff
+		}
+		if w.backoff > w.cfg.MaxBackoff {
+			w.backoff = w.cfg.MaxBackoff
+		}
+		w.retryAt = time.Now().Add(w.backoff)
+		w.err = err
+		return err
+	}
+	w.conn, w.network = conn, network
+	w.backoff, w.err = 0, nil
+	return nil
+}
+
+func (w *Writer) dial() (net.Conn, string, error) {
+	if w.cfg.Network != "" {
+		conn, err := net.DialTimeout(w.cfg.Network, w.cfg.Address, w.cfg.Timeout)
+		if err != nil {
+			return nil, "", fmt.Errorf("logsyslog: %w", err)
+		}
+		return conn, w.cfg.Network, nil
+	}
+	for _, path := range localPaths {
+		for _, network := range []string{"unixgram", "unix"} {
+			if conn, err := net.DialTimeout(network, path, w.cfg.Timeout); err == nil {
+				return conn, network, nil
+			}
+		}
+	}
+	return nil, "", errors.New("logsyslog: no local syslog daemon")
+}
+
+// write sends one message, framed for the connection. A failed write is
+// retried once on a new connection.
+func (w *Writer) write(msg []byte) error {
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if err = w.connect(); err != nil {
+			return err
+		}
+		w.mu.Lock()
+		if w.conn == nil {
+			// Closed meanwhile.
+			w.mu.Unlock()
+			err = errClosed
+			continue
+		}
+		_ = w.conn.SetWriteDeadline(time.Now().Add(w.cfg.Timeout))
+		if _, err = w.conn.Write(w.frame(msg)); err == nil {
+			w.mu.Unlock()
+			return nil
+		}
+		_ = w.conn.Close()
+		w.conn = nil
+		w.mu.Unlock()
+	}
+	return err
+}
+
+var errClosed = errors.New("logsyslog: connection closed")
+
+func (w *Writer) frame(msg []byte) []byte {
+	if w.network == "unixgram" || strings.HasPrefix(w.network, "udp") {
+		return msg
+	}
+	framing := w.cfg.Framing
+	if framing == FramingAuto {
+		framing = FramingNewline
+		if strings.HasPrefix(w.network, "tcp") {
+			framing = FramingOctetCounting
+		}
+	}
+	if framing == FramingOctetCounting {
+		return append([]byte(fmt.Sprintf("%d ", len(msg))), msg...)
+	}
+	framed := make([]byte, 0, len(msg)+1)
+	for _, b := range msg {
+		if b == '\n' {
+			framed = append(framed, "#012"...)
+			continue
+		}
+		framed = append(framed, b)
+	}
+	return append(framed, '\n')
+}
+
+// Write sends p as one message, so that w can be the destination of a
+// logasync.Writer.
+func (w *Writer) Write(p []byte) (int, error) {
+	if err := w.write(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Sync does nothing, the messages are not buffered.
+func (w *Writer) Sync() error {
+	return nil
+}
+
+// Close closes the connection. Writing reconnects.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.conn == nil {
+		return nil
+	}
+	err := w.conn.Close()
+	w.conn = nil
+	return err
+}
@@ -0,0 +1,371 @@
+package logsyslog
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	logasync "github.com/liasece/log/async"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+var testTime = time.Date(2021, 2, 3, 4, 5, 6, 789e6, time.UTC)
+
+func testEntry(level zapcore.Level, message string) zapcore.Entry {
+	return zapcore.Entry{Level: level, Time: testTime, Message: message}
+}
+
+func newTestWriter(t *testing.T, cfg Configuration) *Writer {
+	t.Helper()
+	cfg.AppName, cfg.Hostname = "app", "host"
+	cfg.Timeout = time.Second
+	w, err := NewWriter(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = w.Close() })
+	return w
+}
+
+func write(t *testing.T, core zapcore.Core, ent zapcore.Entry, fields ...zap.Field) {
+	t.Helper()
+	if err := core.Write(ent, fields); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func acceptOne(t *testing.T, ln net.Listener) <-chan net.Conn {
+	t.Helper()
+	conns := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			close(conns)
+			return
+		}
+		conns <- conn
+	}()
+	return conns
+}
+
+// readOctetCounted reads a message framed by its length.
+func readOctetCounted(t *testing.T, r *bufio.Reader) string {
+	t.Helper()
+	size, err := r.ReadString(' ')
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := strconv.Atoi(strings.TrimSuffix(size, " "))
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		t.Fatal(err)
+	}
+	return string(msg)
+}
+
+func TestWriterTCPOctetCounting(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	conns := acceptOne(t, ln)
+
+	w := newTestWriter(t, Configuration{Network: "tcp", Address: ln.Addr().String()})
+	core := NewCore(w, zapcore.DebugLevel).With([]zap.Field{zap.String("user", "ann")})
+	write(t, core, testEntry(zapcore.InfoLevel, "hello"), zap.Namespace("req"), zap.Int("id", 1))
+	write(t, core, testEntry(zapcore.ErrorLevel, "failed"), zap.String("quote", `a"b]`))
+
+	conn := <-conns
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	pid := os.Getpid()
+	want := fmt.Sprintf(`<14>1 2021-02-03T04:05:06.789000Z host app %d - [fields@32473 user="ann" req.id="1"] hello`, pid)
+	if got := readOctetCounted(t, r); got != want {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+	want = fmt.Sprintf(`<11>1 2021-02-03T04:05:06.789000Z host app %d - [fields@32473 user="ann" quote="a\"b\]"] failed`, pid)
+	if got := readOctetCounted(t, r); got != want {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+}
+
+func TestWriterUDPRFC3164(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	w := newTestWriter(t, Configuration{
+		Network:  "udp",
+		Address:  pc.LocalAddr().String(),
+		Format:   RFC3164,
+		Facility: FacilityLocal0,
+	})
+	write(t, NewCore(w, zapcore.DebugLevel), testEntry(zapcore.WarnLevel, "slow"),
+		zap.Duration("took", 1500*time.Millisecond), zap.String("path", "/a b"))
+
+	_ = pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1024)
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprintf(`<132>Feb  3 04:05:06 host app[%d]: slow took=1.5s path="/a b"`, os.Getpid())
+	if got := string(buf[:n]); got != want {
+		t.Errorf("got  %q\nwant %q", got, want)
+	}
+}
+
+func TestWriterReconnects(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	conns := acceptOne(t, ln)
+
+	w := newTestWriter(t, Configuration{Network: "tcp", Address: ln.Addr().String(), Framing: FramingNewline})
+	core := NewCore(w, zapcore.DebugLevel)
+	write(t, core, testEntry(zapcore.InfoLevel, "first"))
+	conn := <-conns
+	_ = conn.Close()
+
+	// The first write after the close may still succeed, the next ones
+	// find the connection reset and reconnect.
+	conns = acceptOne(t, ln)
+	deadline := time.Now().Add(5 * time.Second)
+	var conn2 net.Conn
+	for conn2 == nil && time.Now().Before(deadline) {
+		write(t, core, testEntry(zapcore.InfoLevel, "again"))
+		select {
+		case conn2 = <-conns:
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	if conn2 == nil {
+		t.Fatal("no new connection")
+	}
+	defer conn2.Close()
+	_ = conn2.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := bufio.NewReader(conn2).ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(line, " - again\n") {
+		t.Errorf("got %q", line)
+	}
+}
+
+func TestWriterUnixSockets(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no unix sockets")
+	}
+	dir, err := ioutil.TempDir("", "logsyslog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	prefix := fmt.Sprintf("<15>Feb  3 04:05:06 app[%d]: ", os.Getpid())
+
+	t.Run("unixgram", func(t *testing.T) {
+		path := filepath.Join(dir, "dgram")
+		pc, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer pc.Close()
+
+		w := newTestWriter(t, Configuration{Network: "unixgram", Address: path, Format: RFC3164})
+		write(t, NewCore(w, zapcore.DebugLevel), testEntry(zapcore.DebugLevel, "local"))
+
+		_ = pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+		buf := make([]byte, 1024)
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Local messages leave the host name to the daemon.
+		if got, want := string(buf[:n]), prefix+"local"; got != want {
+			t.Errorf("got  %q\nwant %q", got, want)
+		}
+	})
+
+	t.Run("unix", func(t *testing.T) {
+		path := filepath.Join(dir, "stream")
+		ln, err := net.Listen("unix", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ln.Close()
+		conns := acceptOne(t, ln)
+
+		w := newTestWriter(t, Configuration{Network: "unix", Address: path, Format: RFC3164})
+		core := NewCore(w, zapcore.DebugLevel)
+		write(t, core, testEntry(zapcore.DebugLevel, "one"))
+		write(t, core, testEntry(zapcore.DebugLevel, "two"))
+
+		conn := <-conns
+		defer conn.Close()
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		r := bufio.NewReader(conn)
+		for _, msg := range []string{"one", "two"} {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := prefix + msg + "\n"; line != want {
+				t.Errorf("got  %q\nwant %q", line, want)
+			}
+		}
+	})
+
+	t.Run("unix newlines", func(t *testing.T) {
+		path := filepath.Join(dir, "newlines")
+		ln, err := net.Listen("unix", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ln.Close()
+		conns := acceptOne(t, ln)
+
+		w := newTestWriter(t, Configuration{Network: "unix", Address: path, Format: RFC3164})
+		// The newline must not be appended to the caller's buffer.
+		buf := []byte("a\nbX")
+		if _, err := w.Write(buf[:3]); err != nil {
+			t.Fatal(err)
+		}
+		if string(buf) != "a\nbX" {
+			t.Errorf("buffer changed to %q", buf)
+		}
+
+		conn := <-conns
+		defer conn.Close()
+		_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		line, err := bufio.NewReader(conn).ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := "a#012b\n"; line != want {
+			t.Errorf("got %q, want %q", line, want)
+		}
+	})
+}
+
+func TestWriterConnectsLazily(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	// Nothing listens, NewWriter succeeds all the same.
+	w := newTestWriter(t, Configuration{Network: "tcp", Address: addr, MinBackoff: 200 * time.Millisecond})
+	core := NewCore(w, zapcore.DebugLevel)
+	if err := core.Write(testEntry(zapcore.InfoLevel, "lost"), nil); err == nil {
+		t.Fatal("write succeeded")
+	}
+
+	ln, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Skipf("can't listen on %s again: %v", addr, err)
+	}
+	defer ln.Close()
+	conns := acceptOne(t, ln)
+
+	// The writer backs off before connecting again.
+	if err := core.Write(testEntry(zapcore.InfoLevel, "lost too"), nil); err == nil {
+		t.Fatal("write succeeded during the backoff")
+	}
+	select {
+	case conn := <-conns:
+		conn.Close()
+		t.Fatal("connected during the backoff")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	time.Sleep(200 * time.Millisecond)
+	write(t, core, testEntry(zapcore.InfoLevel, "sent"))
+	conn := <-conns
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if got := readOctetCounted(t, bufio.NewReader(conn)); !strings.HasSuffix(got, " - sent") {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestAsyncCore(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	w := newTestWriter(t, Configuration{Network: "udp", Address: pc.LocalAddr().String(), Format: RFC3164})
+	aw, err := logasync.NewWriter(w, logasync.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer aw.Close()
+	core := NewAsyncCore(w, aw, zapcore.DebugLevel).With([]zap.Field{zap.Int("n", 1)})
+	for i := 0; i < 3; i++ {
+		write(t, core, testEntry(zapcore.InfoLevel, "entry"+strconv.Itoa(i)))
+	}
+	if err := core.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if got := aw.Stats(); got.Written != 3 || got.Buffered != 0 {
+		t.Errorf("got %+v after Sync", got)
+	}
+
+	_ = pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1024)
+	for i := 0; i < 3; i++ {
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := fmt.Sprintf("entry%d n=1", i); !strings.HasSuffix(string(buf[:n]), want) {
+			t.Errorf("got %q, want the suffix %q", buf[:n], want)
+		}
+	}
+}
+
+func TestAsyncCoreUnreachable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := newTestWriter(t, Configuration{Network: "tcp", Address: ln.Addr().String()})
+	ln.Close()
+
+	aw, err := logasync.NewWriter(w, logasync.Configuration{Size: 4, Policy: logasync.DropNewest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer aw.Close()
+	core := NewAsyncCore(w, aw, zapcore.DebugLevel)
+	start := time.Now()
+	for i := 0; i < 100; i++ {
+		write(t, core, testEntry(zapcore.InfoLevel, "lost"))
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("writes took %v", d)
+	}
+}
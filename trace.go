@@ -0,0 +1,108 @@
+package log
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"go.elastic.co/apm"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+)
+
+// TraceFieldNames are the keys of the fields L adds for the trace of a
+// context. An empty key leaves the field out.
+type TraceFieldNames struct {
+	TraceID    string
+	SpanID     string
+	TraceFlags string
+}
+
+var (
+	// DefaultTraceFieldNames are the keys used unless changed by
+	// SetTraceFieldNames or the logging.trace_fields key.
+	DefaultTraceFieldNames = TraceFieldNames{
+		TraceID:    "trace.traceid",
+		SpanID:     "trace.spanid",
+		TraceFlags: "trace.flags",
+	}
+	// OTelTraceFieldNames follow the OpenTelemetry log data model.
+	OTelTraceFieldNames = TraceFieldNames{
+		TraceID:    "trace_id",
+		SpanID:     "span_id",
+		TraceFlags: "trace_flags",
+	}
+	// ECSTraceFieldNames follow the Elastic Common Schema, which has no field
+	// for the trace flags.
+	ECSTraceFieldNames = TraceFieldNames{
+		TraceID: "trace.id",
+		SpanID:  "span.id",
+	}
+)
+
+const (
+	traceFieldsDefault = "default"
+	traceFieldsOTel    = "otel"
+	traceFieldsECS     = "ecs"
+)
+
+var _traceFieldNames atomic.Value
+
+func init() {
+	_traceFieldNames.Store(DefaultTraceFieldNames)
+}
+
+// SetTraceFieldNames changes the keys of the trace fields added by L, until
+// the next InitLog or ReloadLog. It is safe for concurrent use.
+func SetTraceFieldNames(names TraceFieldNames) {
+	_traceFieldNames.Store(names)
+}
+
+func traceFieldNames() TraceFieldNames {
+	return _traceFieldNames.Load().(TraceFieldNames)
+}
+
+// parseTraceFields returns the names of a logging.trace_fields preset.
+func parseTraceFields(key, text string) (TraceFieldNames, error) {
+	switch text {
+	case "", traceFieldsDefault:
+		return DefaultTraceFieldNames, nil
+	case traceFieldsOTel:
+		return OTelTraceFieldNames, nil
+	case traceFieldsECS:
+		return ECSTraceFieldNames, nil
+	}
+	return TraceFieldNames{}, fmt.Errorf("%s: unknown value %q", key, text)
+}
+
+// traceFields returns the fields of the elastic APM span or transaction of
+// ctx, else of its OpenTelemetry span.
+func traceFields(ctx context.Context) []zap.Field {
+	names := traceFieldNames()
+	if span := apm.SpanFromContext(ctx); span != nil {
+		return apmTraceFields(names, span.TraceContext())
+	}
+	if tx := apm.TransactionFromContext(ctx); tx != nil {
+		return apmTraceFields(names, tx.TraceContext())
+	}
+	if sc := trace.SpanContextFromContext(ctx); sc.IsValid() {
+		fields := make([]zap.Field, 0, 3)
+		fields = appendTraceField(fields, names.TraceID, sc.TraceID().String())
+		fields = appendTraceField(fields, names.SpanID, sc.SpanID().String())
+		return appendTraceField(fields, names.TraceFlags, sc.TraceFlags().String())
+	}
+	return nil
+}
+
+func apmTraceFields(names TraceFieldNames, tc apm.TraceContext) []zap.Field {
+	fields := make([]zap.Field, 0, 2)
+	fields = appendTraceField(fields, names.TraceID, tc.Trace.String())
+	return appendTraceField(fields, names.SpanID, tc.Span.String())
+}
+
+func appendTraceField(fields []zap.Field, key, value string) []zap.Field {
+	if key == "" {
+		return fields
+	}
+	return append(fields, zap.String(key, value))
+}